@@ -0,0 +1,384 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// adminLoginDelay slows down guessing the admin password.
+const adminLoginDelay = time.Second
+
+// setupAdminRoutes adds the routes of the admin area, used by the contest
+// operator to watch the judge and the sessions.
+func (srv *Server) setupAdminRoutes(r *mux.Router) {
+	r.HandleFunc("/admin", srv.adminHandler).Methods("GET")
+	r.HandleFunc("/admin/login", srv.adminLoginHandler).Methods("POST")
+	r.HandleFunc("/admin/logout", srv.adminLogoutHandler).Methods("POST")
+	r.Handle("/admin/getstate", srv.adminWrapper(srv.adminStateHandler)).Methods("GET")
+	r.Handle("/admin/getsubmissions", srv.adminWrapper(srv.adminSubmissionsHandler)).Methods("GET")
+	r.Handle("/admin/submission/{id:[0-9]+}/cancel", srv.adminWrapper(srv.adminCancelHandler)).Methods("POST")
+	r.Handle("/admin/submission/{id:[0-9]+}/rejudge", srv.adminWrapper(srv.adminRejudgeHandler)).Methods("POST")
+	r.Handle("/admin/reload", srv.adminWrapper(srv.adminReloadHandler)).Methods("POST")
+	r.Handle("/admin/getclarifications", srv.adminWrapper(srv.adminClarificationsHandler)).Methods("GET")
+	r.Handle("/admin/clarification/{id:[0-9]+}/answer", srv.adminWrapper(srv.adminAnswerHandler)).Methods("POST")
+	r.Handle("/admin/announce", srv.adminWrapper(srv.adminAnnounceHandler)).Methods("POST")
+}
+
+// adminWrapper only lets through requests from browsers logged in the admin
+// area.
+func (srv *Server) adminWrapper(f func(http.ResponseWriter, *http.Request)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(srv.AdminPassword) == 0 {
+			srv.notFoundHandler(w, r)
+			return
+		}
+
+		if !srv.sessionManager.IsAdmin(r, srv.AdminPassword) {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(struct{ Error string }{"Not logged in the admin area"})
+			return
+		}
+
+		f(w, r)
+	})
+}
+
+func (srv *Server) adminHandler(w http.ResponseWriter, r *http.Request) {
+	if len(srv.AdminPassword) == 0 {
+		srv.notFoundHandler(w, r)
+		return
+	}
+
+	srv.render(w, r, "admin.html", map[string]interface{}{
+		"LoggedIn":      srv.sessionManager.IsAdmin(r, srv.AdminPassword),
+		"WrongPassword": r.FormValue("wrong"),
+	}, http.StatusOK)
+}
+
+func (srv *Server) adminLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if len(srv.AdminPassword) == 0 {
+		srv.notFoundHandler(w, r)
+		return
+	}
+
+	// the hashes are compared, so neither the password nor its length leak
+	// through the time taken
+	given, expected := adminToken(r.FormValue("password")), adminToken(srv.AdminPassword)
+	if subtle.ConstantTimeCompare([]byte(given), []byte(expected)) != 1 {
+		srv.Logger.Print("Failed admin login from ", r.RemoteAddr)
+		time.Sleep(adminLoginDelay)
+		http.Redirect(w, r, "/admin?wrong=true", http.StatusFound)
+		return
+	}
+
+	if err := srv.sessionManager.SetAdmin(w, srv.AdminPassword); err != nil {
+		srv.errorHandler(err, w, r)
+		return
+	}
+
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
+
+func (srv *Server) adminLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	srv.sessionManager.SetAdmin(w, "")
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
+
+// adminStateHandler reports what the judge workers are doing, the items in
+// the judge queue and the open sessions.
+func (srv *Server) adminStateHandler(w http.ResponseWriter, r *http.Request) {
+	type session struct {
+		SID         string
+		User        string
+		LoggedIn    bool
+		Contest     string
+		Submissions int
+		Tests       int
+	}
+
+	type result struct {
+		Workers  []WorkerStatus
+		Queue    []QueueEntry
+		Sessions []session
+		Multi    bool
+	}
+
+	ret := result{
+		Workers:  srv.Judge.Workers(),
+		Queue:    srv.Judge.Queue(),
+		Sessions: make([]session, 0),
+		Multi:    srv.Roster != nil,
+	}
+
+	for _, s := range srv.sessionManager.Sessions() {
+		info := session{
+			SID:         s.GetID(),
+			User:        s.GetUser(),
+			LoggedIn:    len(s.GetPassword()) > 0,
+			Submissions: len(s.GetSubmissions()),
+			Tests:       len(s.GetTests()),
+		}
+
+		if db := s.GetDatabase(); db != nil {
+			if contest, err := db.Contest(); err == nil {
+				info.Contest = contest.Title
+			}
+		}
+
+		ret.Sessions = append(ret.Sessions, info)
+	}
+
+	sort.Slice(ret.Sessions, func(i, j int) bool {
+		if ret.Sessions[i].User != ret.Sessions[j].User {
+			return ret.Sessions[i].User < ret.Sessions[j].User
+		}
+		return ret.Sessions[i].SID < ret.Sessions[j].SID
+	})
+
+	json.NewEncoder(w).Encode(ret)
+}
+
+// adminSubmissionsHandler lists the submissions of every session, with their
+// full verdicts and source.
+func (srv *Server) adminSubmissionsHandler(w http.ResponseWriter, r *http.Request) {
+	type submission struct {
+		TaskVerdict
+		User string
+	}
+
+	subs := make([]submission, 0)
+	for _, s := range srv.sessionManager.Sessions() {
+		for _, v := range s.GetSubmissions() {
+			subs = append(subs, submission{v, s.GetUser()})
+		}
+	}
+
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].ID > subs[j].ID
+	})
+
+	json.NewEncoder(w).Encode(subs)
+}
+
+// adminSubmission returns the ID in the request path and the session which
+// sent that submission.
+func (srv *Server) adminSubmission(r *http.Request) (uint32, *Session, error) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sub, ok := srv.Judge.Submission(uint32(id))
+	if !ok {
+		return 0, nil, errors.New("Submission " + strconv.Itoa(int(id)) + " doesn't exist")
+	}
+
+	s, ok := srv.sessionManager.GetSession(sub.SID)
+	if !ok {
+		return 0, nil, errors.New("The session of submission " + strconv.Itoa(int(id)) + " was closed")
+	}
+
+	return uint32(id), s, nil
+}
+
+func (srv *Server) adminCancelHandler(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Error string
+		ID    uint32
+	}
+
+	encoder := json.NewEncoder(w)
+
+	id, _, err := srv.adminSubmission(r)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	err = srv.Judge.Cancel(id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	encoder.Encode(result{"", id})
+}
+
+func (srv *Server) adminRejudgeHandler(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Error string
+		ID    uint32
+	}
+
+	encoder := json.NewEncoder(w)
+
+	id, s, err := srv.adminSubmission(r)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	err = srv.rejudge(s, id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	encoder.Encode(result{"", id})
+}
+
+// adminReloadHandler replaces the database shared by the contestants of the
+// roster, either by the uploaded one or by reading it again from its file,
+// so changes to the contest apply without restarting. An uploaded database is
+// only used until the program restarts.
+func (srv *Server) adminReloadHandler(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Error string
+		Title string
+	}
+
+	encoder := json.NewEncoder(w)
+
+	if srv.Roster == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(result{"The contest can only be reloaded in the multi-contestant mode", ""})
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(result{err.Error(), ""})
+		return
+	}
+
+	_, password := srv.sharedContest()
+	if len(r.FormValue("password")) > 0 {
+		password = []byte(r.FormValue("password"))
+	}
+
+	var db *Database
+	var err error
+
+	if file, _, ferr := r.FormFile("contest"); ferr == nil {
+		defer file.Close()
+
+		db, err = OpenDatabase(file, srv.DatabasePath)
+		if err == nil {
+			var auth bool
+			auth, err = db.Authenticate(password)
+			if err == nil && !auth {
+				err = errors.New("Wrong password for the uploaded contest")
+			}
+			if err != nil {
+				db.Clear()
+			}
+		}
+	} else {
+		db, err = CopyDatabase(srv.ContestPath, srv.DatabasePath, password)
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(result{err.Error(), ""})
+		return
+	}
+
+	contest, err := db.Contest()
+	if err != nil {
+		db.Clear()
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(result{err.Error(), ""})
+		return
+	}
+
+	// the previous database isn't cleared, as submissions being judged may
+	// still read it; its file is removed on the next start
+	srv.contestLock.Lock()
+	srv.Contest = db
+	srv.ContestPassword = password
+	srv.contestLock.Unlock()
+
+	for _, s := range srv.sessionManager.UserSessions() {
+		s.SetPassword(password)
+		s.SetDatabase(db)
+	}
+
+	srv.Logger.Print("Contest reloaded: ", contest.Title)
+	encoder.Encode(result{"", contest.Title})
+}
+
+// adminClarificationsHandler lists the questions of every session, the
+// unanswered ones first, and the announcements already sent.
+func (srv *Server) adminClarificationsHandler(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Clarifications []Clarification
+		Announcements  []Announcement
+	}
+
+	clarifications := srv.sessionManager.Clarifications()
+	sort.Slice(clarifications, func(i, j int) bool {
+		answeredI := !clarifications[i].Answered.IsZero()
+		answeredJ := !clarifications[j].Answered.IsZero()
+		if answeredI != answeredJ {
+			return !answeredI
+		}
+		return clarifications[i].ID > clarifications[j].ID
+	})
+
+	json.NewEncoder(w).Encode(result{clarifications, srv.sessionManager.Announcements()})
+}
+
+func (srv *Server) adminAnswerHandler(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Error string
+		ID    uint32
+	}
+
+	encoder := json.NewEncoder(w)
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	broadcast := r.FormValue("broadcast") == "true"
+	err = srv.sessionManager.AnswerClarification(uint32(id), strings.TrimSpace(r.FormValue("answer")), broadcast)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	encoder.Encode(result{"", uint32(id)})
+}
+
+func (srv *Server) adminAnnounceHandler(w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Error string
+	}
+
+	encoder := json.NewEncoder(w)
+
+	err := srv.sessionManager.Announce(r.FormValue("task"), strings.TrimSpace(r.FormValue("text")))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(result{err.Error()})
+		return
+	}
+
+	encoder.Encode(result{""})
+}
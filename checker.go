@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit codes used by testlib-style checkers and interactors to indicate their
+// verdict.
+const (
+	checkerExitOK    = 0
+	checkerExitWA    = 1
+	checkerExitPE    = 2
+	checkerExitFail  = 3
+	checkerExitDirt  = 4
+	checkerExitPoint = 7
+	checkerExitEOF   = 8
+)
+
+const (
+	taskProgramTimeLimit   = 10 * time.Second
+	taskProgramMemoryLimit = 1 << 20 // 1GB
+)
+
+// taskProgram stores information related to a program shipped with a task,
+// such as a checker or an interactor, compiled inside its own sandbox.
+type taskProgram struct {
+	box        *Box
+	lang       Language
+	executable string
+}
+
+// prepareTaskProgram copies the specified files into the n-th sandbox of the
+// worker and compiles the one named source.
+func (w *judgeWorker) prepareTaskProgram(n int, files []FileData, source string) (*taskProgram, error) {
+	lang := LanguageByExtension(filepath.Ext(source))
+	if lang == nil {
+		return nil, errors.New("No language for " + source)
+	}
+
+	box, err := w.prepare(n, lang, files)
+	if err != nil {
+		return nil, err
+	}
+
+	executable := strings.TrimSuffix(source, filepath.Ext(source))
+	compilationCommand := lang.CompilationCommand([]string{source}, executable)
+
+	ok, compilationResult, compilationExtra := w.compile(box, compilationCommand, nil)
+	if !ok {
+		box.Clear()
+		return nil, errors.New(compilationExtra)
+	} else if compilationResult != ResultCompSuccess {
+		box.Clear()
+		return nil, errors.New("Compilation failed: " + compilationExtra)
+	}
+
+	return &taskProgram{box: box, lang: lang, executable: executable}, nil
+}
+
+// writeTest writes the test input, the submission output and the expected
+// output into the sandbox, as the files input, output and answer.
+func (p *taskProgram) writeTest(test TestData, output []byte) error {
+	for name, content := range map[string][]byte{
+		"input":  test.Input,
+		"output": output,
+		"answer": test.Output,
+	} {
+		err := ioutil.WriteFile(filepath.Join(p.box.BoxPath, "box", name), content, 0666)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// config returns the BoxConfig used to run the program with the specified
+// arguments.
+func (p *taskProgram) config(args []string) *BoxConfig {
+	command := p.lang.EvaluationCommand(p.executable, args, taskProgramMemoryLimit)
+	boxConfig := &BoxConfig{
+		Path:          command[0],
+		Args:          command,
+		Env:           env,
+		EnableCgroups: true,
+		CPUTimeLimit:  taskProgramTimeLimit,
+		WallTimeLimit: taskProgramTimeLimit,
+	}
+
+	if p.lang.UseMemoryLimit() {
+		boxConfig.MemoryLimit = taskProgramMemoryLimit
+	}
+
+	return boxConfig
+}
+
+// check runs the checker with the test input, the submission output and the
+// expected output. It returns the test result, the fraction of the test score
+// obtained and the checker message. An error is returned only when the checker
+// itself has failed.
+func (p *taskProgram) check(test TestData, output []byte) (int, float64, string, error) {
+	err := p.writeTest(test, output)
+	if err != nil {
+		return ResultNothing, 0, "", err
+	}
+
+	messageFile, err := os.Create(filepath.Join(p.box.BoxPath, "box", ".message"))
+	if err != nil {
+		return ResultNothing, 0, "", err
+	}
+
+	boxConfig := p.config([]string{"input", "output", "answer"})
+	boxConfig.Stdout = messageFile
+	boxConfig.Stderr = messageFile
+
+	result := p.box.Run(boxConfig)
+
+	messageFile.Close()
+	return p.verdict(result)
+}
+
+// verdict interprets the exit code and the message written by the program,
+// returning the test result, the fraction of the test score obtained and the
+// message. An error is returned only when the program itself has failed.
+func (p *taskProgram) verdict(result *BoxResult) (int, float64, string, error) {
+	messageBytes, err := ioutil.ReadFile(filepath.Join(p.box.BoxPath, "box", ".message"))
+	if err != nil {
+		return ResultNothing, 0, "", err
+	}
+
+	message := strings.TrimSpace(string(messageBytes))
+
+	if testingFlag {
+		fmt.Printf("Task program: %+v %s\n", result, message)
+	}
+
+	switch result.Status {
+	case StatusError:
+		return ResultNothing, 0, "", errors.New(result.Error)
+	case StatusWTL, StatusCTL:
+		return ResultNothing, 0, "", errors.New("Time limit exceeded")
+	case StatusMLE:
+		return ResultNothing, 0, "", errors.New("Memory limit exceeded")
+	case StatusOLE:
+		return ResultNothing, 0, "", errors.New("Output limit exceeded")
+	case StatusSig:
+		return ResultNothing, 0, "", errors.New("Killed by signal " + result.Signal.String())
+	}
+
+	switch result.ExitCode {
+	case checkerExitOK:
+		return ResultCorrect, 1, message, nil
+	case checkerExitWA, checkerExitPE, checkerExitDirt, checkerExitEOF:
+		return ResultWrong, 0, message, nil
+	case checkerExitPoint:
+		score, message, err := parseCheckerPoints(message)
+		if err != nil {
+			return ResultNothing, 0, "", errors.New("Failed: " + err.Error())
+		} else if score >= 1 {
+			return ResultCorrect, 1, message, nil
+		} else if score <= 0 {
+			return ResultWrong, 0, message, nil
+		}
+		return ResultPartial, score, message, nil
+	case checkerExitFail:
+		return ResultNothing, 0, "", errors.New("Failed: " + message)
+	}
+
+	return ResultNothing, 0, "", errors.New("Exited with code " + strconv.Itoa(result.ExitCode) + ": " + message)
+}
+
+// parseCheckerPoints extracts the score from a message in the form
+// "points <score> <message>", as written by testlib's quitp. A message without
+// a score is worth nothing, while a score that isn't finite is an error, as it
+// would spread to the batch and task scores.
+func parseCheckerPoints(message string) (float64, string, error) {
+	message = strings.TrimSpace(strings.TrimPrefix(message, "points"))
+
+	fields := strings.SplitN(message, " ", 2)
+	score, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, message, nil
+	} else if math.IsNaN(score) || math.IsInf(score, 0) {
+		return 0, "", errors.New("Invalid score " + fields[0])
+	}
+
+	if len(fields) > 1 {
+		message = strings.TrimSpace(fields[1])
+	} else {
+		message = ""
+	}
+
+	return score, message, nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestParseCheckerPoints(t *testing.T) {
+	cases := []struct {
+		message string
+		score   float64
+		rest    string
+		ok      bool
+	}{
+		{"points 0.5 half of the answers", 0.5, "half of the answers", true},
+		{"points 1", 1, "", true},
+		{"points 0", 0, "", true},
+		{"points  0.25   spaced", 0.25, "spaced", true},
+		{"points -1 negative", -1, "negative", true},
+		{"points wrong answer", 0, "wrong answer", true},
+		{"points", 0, "", true},
+		{"points 1e400", 0, "1e400", true},
+		{"points nan", 0, "", false},
+		{"points NaN message", 0, "", false},
+		{"points inf", 0, "", false},
+		{"points -Inf message", 0, "", false},
+		{"points +infinity", 0, "", false},
+	}
+
+	for _, c := range cases {
+		score, rest, err := parseCheckerPoints(c.message)
+		if (err == nil) != c.ok {
+			t.Errorf("parseCheckerPoints(%q) error = %v, want ok = %v", c.message, err, c.ok)
+			continue
+		}
+		if score != c.score || rest != c.rest {
+			t.Errorf("parseCheckerPoints(%q) = %v, %q, want %v, %q", c.message, score, rest, c.score, c.rest)
+		}
+	}
+}
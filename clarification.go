@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const maxClarificationLength = 2000
+
+// Clarification is a question sent by a contestant, optionally about a task,
+// and its answer by the contest admin.
+type Clarification struct {
+	ID       uint32
+	SID      string
+	User     string
+	TaskName string
+	Question string
+	When     time.Time
+	Answer   string
+	Answered time.Time
+
+	// Broadcast means the answer was also sent to everybody as an
+	// announcement.
+	Broadcast bool
+}
+
+// Announcement is a message from the contest admin to every contestant,
+// which may be the answer of a clarification, in which case Question holds
+// what was asked.
+type Announcement struct {
+	ID       uint32
+	When     time.Time
+	TaskName string
+	Question string
+	Text     string
+}
+
+// AskClarification records a question of the session to the contest admin.
+func (m *SessionManager) AskClarification(s *Session, taskName, question string) (Clarification, error) {
+	if len(question) == 0 {
+		return Clarification{}, errors.New("The question is empty")
+	} else if len(question) > maxClarificationLength {
+		return Clarification{}, errors.New("The question exceeds " + strconv.Itoa(maxClarificationLength) + " characters")
+	}
+
+	c := Clarification{
+		ID:       atomic.AddUint32(&m.clarificationID, 1),
+		SID:      s.GetID(),
+		User:     s.GetUser(),
+		TaskName: taskName,
+		Question: question,
+		When:     time.Now(),
+	}
+
+	s.lock.Lock()
+	s.clarifications = append(s.clarifications, c)
+	s.persist()
+	s.lock.Unlock()
+
+	return c, nil
+}
+
+// Clarifications returns the clarifications asked by every session.
+func (m *SessionManager) Clarifications() []Clarification {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ret := make([]Clarification, 0)
+	for _, s := range m.sessions {
+		s.lock.Lock()
+		ret = append(ret, s.clarifications...)
+		s.lock.Unlock()
+	}
+	return ret
+}
+
+// AnswerClarification sends the answer of a clarification to the session
+// which asked it or, when broadcast is set, to everybody as an announcement.
+func (m *SessionManager) AnswerClarification(id uint32, answer string, broadcast bool) error {
+	if len(answer) == 0 {
+		return errors.New("The answer is empty")
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, s := range m.sessions {
+		s.lock.Lock()
+		for i := range s.clarifications {
+			c := &s.clarifications[i]
+			if c.ID != id {
+				continue
+			}
+
+			c.Answer = answer
+			c.Answered = time.Now()
+			c.Broadcast = broadcast
+
+			answered := *c
+			s.addEvent(SessionEvent{Type: EventClarification, Clarification: &answered})
+			s.persist()
+			s.lock.Unlock()
+
+			if broadcast {
+				m.announce(Announcement{
+					TaskName: answered.TaskName,
+					Question: answered.Question,
+					Text:     answer,
+				})
+			}
+			return nil
+		}
+		s.lock.Unlock()
+	}
+
+	return errors.New("Clarification " + strconv.Itoa(int(id)) + " doesn't exist")
+}
+
+// Announce sends a message from the contest admin to every session.
+func (m *SessionManager) Announce(taskName, text string) error {
+	if len(text) == 0 {
+		return errors.New("The announcement is empty")
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.announce(Announcement{TaskName: taskName, Text: text})
+	return nil
+}
+
+// announce stores an announcement and pushes it to every session. It should
+// be called with the session manager lock held.
+func (m *SessionManager) announce(a Announcement) {
+	a.ID = atomic.AddUint32(&m.announcementID, 1)
+	a.When = time.Now()
+
+	m.announcements = append(m.announcements, a)
+	if m.store != nil {
+		if err := m.store.saveAnnouncements(m.announcements); err != nil {
+			log.Print("Can't store announcements: ", err)
+		}
+	}
+
+	for _, s := range m.sessions {
+		s.lock.Lock()
+		s.addEvent(SessionEvent{Type: EventAnnouncement, Announcement: &a})
+		s.lock.Unlock()
+	}
+}
+
+// Announcements returns every announcement sent so far.
+func (m *SessionManager) Announcements() []Announcement {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return append([]Announcement{}, m.announcements...)
+}
+
+// GetClarifications returns the clarifications asked by the session.
+func (s *Session) GetClarifications() []Clarification {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]Clarification{}, s.clarifications...)
+}
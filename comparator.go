@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// defaultComparator keeps the comparison used before comparators could be
+// chosen, so tasks which don't choose one are judged as they always were.
+const defaultComparator = "spaces"
+
+// Comparator decides whether the output of a program matches the expected
+// output of a test.
+type Comparator func(output, expected []byte) bool
+
+// comparators maps each comparison mode to a function building its
+// Comparator from the argument following the colon (e.g. "1e-6" in
+// "float:1e-6"), which is empty when not specified.
+var comparators = map[string]func(arg string) (Comparator, error){
+	"exact":  noArgComparator(compareExact),
+	"lines":  noArgComparator(compareLines),
+	"spaces": noArgComparator(compareSpaces),
+	"tokens": noArgComparator(compareTokens),
+	"icase":  noArgComparator(compareTokensIgnoringCase),
+	"float":  floatComparator,
+}
+
+// NewComparator returns the Comparator described by the specified mode, in
+// the form name or name:arg. An empty mode means the default comparator.
+func NewComparator(mode string) (Comparator, error) {
+	if len(mode) == 0 {
+		mode = defaultComparator
+	}
+
+	info := strings.SplitN(mode, ":", 2)
+	build, ok := comparators[info[0]]
+	if !ok {
+		return nil, errors.New("Unknown comparator " + info[0])
+	}
+
+	var arg string
+	if len(info) > 1 {
+		arg = info[1]
+	}
+
+	return build(arg)
+}
+
+func noArgComparator(cmp Comparator) func(string) (Comparator, error) {
+	return func(arg string) (Comparator, error) {
+		if len(arg) > 0 {
+			return nil, errors.New("Unexpected comparator argument " + arg)
+		}
+		return cmp, nil
+	}
+}
+
+// Compares both outputs byte by byte
+func compareExact(output, expected []byte) bool {
+	return bytes.Equal(output, expected)
+}
+
+// Compares both outputs line by line, ignoring trailing spaces in each line
+// and trailing empty lines
+func compareLines(output, expected []byte) bool {
+	a := splitLines(output)
+	b := splitLines(expected)
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func splitLines(data []byte) [][]byte {
+	lines := bytes.Split(data, []byte("\n"))
+	for i := range lines {
+		lines[i] = bytes.TrimRight(lines[i], " \t\r\v\f")
+	}
+
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// Compares both outputs after collapsing each run of whitespace into a single
+// space, so whitespace may vary in amount but not be missing or extra
+func compareSpaces(output, expected []byte) bool {
+	return collapseSpaces(output) == collapseSpaces(expected)
+}
+
+func collapseSpaces(data []byte) string {
+	out := strings.Builder{}
+	out.Grow(len(data))
+
+	white := false
+	for _, c := range string(data) {
+		if unicode.IsSpace(c) {
+			if !white {
+				out.WriteByte(' ')
+			}
+			white = true
+		} else {
+			out.WriteRune(c)
+			white = false
+		}
+	}
+
+	return out.String()
+}
+
+// Compares both outputs token by token, ignoring any amount of whitespace
+// between them
+func compareTokens(output, expected []byte) bool {
+	return compareFields(output, expected, bytes.Equal)
+}
+
+// Compares both outputs token by token, ignoring letter case
+func compareTokensIgnoringCase(output, expected []byte) bool {
+	return compareFields(output, expected, bytes.EqualFold)
+}
+
+// Compares both outputs token by token, accepting numbers within the
+// specified absolute or relative error
+func floatComparator(arg string) (Comparator, error) {
+	epsilon := 1e-6
+	if len(arg) > 0 {
+		var err error
+		epsilon, err = strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(epsilon) || math.IsInf(epsilon, 0) || epsilon < 0 {
+			return nil, errors.New("Invalid comparator precision " + arg)
+		}
+	}
+
+	return func(output, expected []byte) bool {
+		return compareFields(output, expected, func(a, b []byte) bool {
+			if bytes.Equal(a, b) {
+				return true
+			}
+
+			x, err := strconv.ParseFloat(string(a), 64)
+			if err != nil || math.IsNaN(x) || math.IsInf(x, 0) {
+				return false
+			}
+
+			y, err := strconv.ParseFloat(string(b), 64)
+			if err != nil {
+				return false
+			}
+
+			return math.Abs(x-y) <= epsilon*math.Max(1, math.Abs(y))
+		})
+	}, nil
+}
+
+func compareFields(output, expected []byte, equal func(a, b []byte) bool) bool {
+	a := bytes.Fields(output)
+	b := bytes.Fields(expected)
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !equal(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
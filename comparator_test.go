@@ -0,0 +1,143 @@
+package main
+
+import "testing"
+
+func TestNewComparator(t *testing.T) {
+	cases := []struct {
+		mode string
+		ok   bool
+	}{
+		{"", true},
+		{"exact", true},
+		{"lines", true},
+		{"spaces", true},
+		{"tokens", true},
+		{"icase", true},
+		{"float", true},
+		{"float:", true},
+		{"float:1e-9", true},
+		{"float:0", true},
+		{"float:abc", false},
+		{"float:-1e-6", false},
+		{"float:nan", false},
+		{"float:inf", false},
+		{"tokens:1", false},
+		{"exact:", true},
+		{"unknown", false},
+		{"Tokens", false},
+	}
+
+	for _, c := range cases {
+		_, err := NewComparator(c.mode)
+		if (err == nil) != c.ok {
+			t.Errorf("NewComparator(%q) error = %v, want ok = %v", c.mode, err, c.ok)
+		}
+	}
+}
+
+func TestComparators(t *testing.T) {
+	cases := []struct {
+		mode     string
+		output   string
+		expected string
+		want     bool
+	}{
+		{"exact", "1 2\n", "1 2\n", true},
+		{"exact", "1 2", "1 2\n", false},
+		{"exact", "1 2 \n", "1 2\n", false},
+		{"exact", "1 2\r\n", "1 2\n", false},
+		{"exact", "", "", true},
+
+		{"lines", "1 2\n", "1 2\n", true},
+		{"lines", "1 2", "1 2\n", true},
+		{"lines", "1 2  \t\n", "1 2\n", true},
+		{"lines", "1 2\r\n3\r\n", "1 2\n3\n", true},
+		{"lines", "1 2\n\n\n", "1 2\n", true},
+		{"lines", "1  2\n", "1 2\n", false},
+		{"lines", " 1 2\n", "1 2\n", false},
+		{"lines", "1\n2\n", "1 2\n", false},
+		{"lines", "\n1 2\n", "1 2\n", false},
+
+		{"tokens", "1 2\n", "1 2\n", true},
+		{"tokens", "1 2", "1 2\n", true},
+		{"tokens", "  1\n\n2  \t", "1 2\n", true},
+		{"tokens", "1\r\n2\r\n", "1 2\n", true},
+		{"tokens", "12\n", "1 2\n", false},
+		{"tokens", "1 2 3\n", "1 2\n", false},
+		{"tokens", "yes\n", "YES\n", false},
+		{"tokens", "", "\n", true},
+
+		{"icase", "yes\n", "YES\n", true},
+		{"icase", "Yes  No", "YES\nno\n", true},
+		{"icase", "yes\n", "YES NO\n", false},
+		{"icase", "yess\n", "YES\n", false},
+
+		{"float", "0.3333333\n", "0.333333333\n", true},
+		{"float", "0.334\n", "0.333333333\n", false},
+		{"float", "1000000.5\n", "1000000\n", true},
+		{"float", "1000002\n", "1000000\n", false},
+		{"float", "1.0 2.0\n", "1 2", true},
+		{"float", "1.0\n", "1 2\n", false},
+		{"float", "abc\n", "abc\n", true},
+		{"float", "abc\n", "abd\n", false},
+		{"float", "nan\n", "nan\n", true},
+		{"float", "nan\n", "1\n", false},
+		{"float", "NaN\n", "nan\n", false},
+		{"float", "inf\n", "inf\n", true},
+		{"float", "Inf\n", "inf\n", false},
+		{"float", "1e400\n", "1e308\n", false},
+		{"float", "inf\n", "1e308\n", false},
+		{"float:1e-2", "0.334\n", "0.333\n", true},
+		{"float:1e-2", "0.35\n", "0.333\n", false},
+		{"float:0", "0.1\n", "0.10\n", true},
+		{"float:0", "0.1\n", "0.1000001\n", false},
+	}
+
+	for _, c := range cases {
+		compare, err := NewComparator(c.mode)
+		if err != nil {
+			t.Fatalf("NewComparator(%q): %v", c.mode, err)
+		}
+
+		if got := compare([]byte(c.output), []byte(c.expected)); got != c.want {
+			t.Errorf("%s: compare(%q, %q) = %v, want %v", c.mode, c.output, c.expected, got, c.want)
+		}
+	}
+}
+
+// TestDefaultComparator pins the comparison used before comparators could be
+// chosen: each run of whitespace is the same as any other, but whitespace
+// can't be missing or extra, not even at the end of the output.
+func TestDefaultComparator(t *testing.T) {
+	cases := []struct {
+		output   string
+		expected string
+		want     bool
+	}{
+		{"1 2\n", "1 2\n", true},
+		{"1  2\n", "1 2\n", true},
+		{"1\t2\r\n", "1 2\n", true},
+		{"1\n2\n", "1 2\n", true},
+		{"1 2\n\n\n", "1 2\n", true},
+		{"1 2  \n", "1 2\n", true},
+		{"1 2", "1 2\n", false},
+		{" 1 2\n", "1 2\n", false},
+		{"12\n", "1 2\n", false},
+		{"1 2 3\n", "1 2\n", false},
+		{"yes\n", "YES\n", false},
+		{"", "", true},
+		{"\n", "", false},
+		{"1\u00a02\n", "1 2\n", true},
+	}
+
+	compare, err := NewComparator("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		if got := compare([]byte(c.output), []byte(c.expected)); got != c.want {
+			t.Errorf("compare(%q, %q) = %v, want %v", c.output, c.expected, got, c.want)
+		}
+	}
+}
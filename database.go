@@ -14,31 +14,90 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Feedback levels, indicating how much per-test information is revealed
+// to the contestant. Contests that don't set one reveal only the results of
+// the batches, as FeedbackNone, so full feedback must be chosen explicitly.
+const (
+	FeedbackNone    = "none"
+	FeedbackResults = "results"
+	FeedbackFull    = "full"
+)
+
 // ContestData stores a contest's information
 type ContestData struct {
-	Name  string
-	Title string
-	Tasks []TaskData
+	Name     string
+	Title    string
+	Feedback string
+	Tasks    []TaskData
+
+	// FullEvaluation runs every test of all submissions, even after a batch
+	// has failed, as is useful for training and for validating tests, while
+	// FullEvaluationOption lets contestants ask for it in each submission.
+	FullEvaluation       bool
+	FullEvaluationOption bool
+
+	// Duration is the time, in minutes, contestants have to send submissions,
+	// counted from StartTime or, when it's not set, from their first login.
+	// Zero means the contest never ends.
+	Duration  int
+	StartTime time.Time
+
+	// FreezeAfter hides the results of the submissions sent after that many
+	// minutes of contest, until it ends. Zero means results are never hidden,
+	// and it can only be set along with Duration.
+	FreezeAfter int
+
+	// Scoring is how the submissions of a task are combined into its score,
+	// either ScoringBest (the default) or ScoringSubtask.
+	Scoring string
 }
 
+// Task types, indicating how a submission interacts with the tests
+const (
+	TaskTypeBatch       = "batch"
+	TaskTypeInteractive = "interactive"
+	TaskTypeOutputOnly  = "output-only"
+)
+
+// Aggregation rules, indicating how the scores of the tests of a batch are
+// combined into the batch score
+const (
+	AggregationMin     = "min"
+	AggregationSum     = "sum"
+	AggregationProduct = "product"
+)
+
 // TaskData stores a task's information
 type TaskData struct {
 	Name        string
 	Title       string
+	Type        string
 	TimeLimit   int
 	MemoryLimit int
+	OutputLimit int
 	NTests      int
 	Batches     []BatchData
+	Checker     string
+	Comparator  string
+	Interactor  string
+	Aggregation string
+
+	// InputFile and OutputFile name the files the submission reads its input
+	// from and writes its output to, instead of the standard ones
+	InputFile  string
+	OutputFile string
 }
 
 // BatchData stores information about a batch of test cases
 type BatchData struct {
-	Value int
-	Tests []int
+	Value       int
+	Tests       []int
+	Aggregation string
 }
 
 // StatementData stores a tasks' html and pdf statements
@@ -55,6 +114,13 @@ type TestData struct {
 	Output []byte
 }
 
+// FileData stores an auxiliary file's name, content and, optionally, mode
+type FileData struct {
+	Name    string
+	Content []byte
+	Mode    os.FileMode
+}
+
 // Database stores information related to a user-specific database
 type Database struct {
 	path    string
@@ -93,6 +159,46 @@ func OpenDatabase(formFile multipart.File, folder string) (*Database, error) {
 	}, nil
 }
 
+// CopyDatabase copies a database file into the specified folder, as
+// OpenDatabase does with uploaded ones, checking its password.
+func CopyDatabase(path, folder string, password []byte) (*Database, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	db, err := OpenDatabase(file, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := db.Authenticate(password)
+	if err != nil {
+		db.Clear()
+		return nil, err
+	} else if !auth {
+		db.Clear()
+		return nil, errors.New("Wrong password for " + path)
+	}
+
+	return db, nil
+}
+
+// LoadDatabase opens a database file previously copied by OpenDatabase, such
+// as one belonging to a session restored after a restart.
+func LoadDatabase(path string) (*Database, error) {
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Database{
+		path:    path,
+		archive: archive,
+	}, nil
+}
+
 // Clear should be called when the database will not be used anymore, probably
 // at the end of the program execution or at user logout.
 func (db *Database) Clear() error {
@@ -207,7 +313,16 @@ func (db *Database) Contest() (ContestData, error) {
 
 	var contest ContestData
 	err = json.Unmarshal(content, &contest)
-	return contest, err
+	if err != nil {
+		return ContestData{}, err
+	}
+
+	// results frozen in a contest that never ends would stay hidden forever
+	if contest.FreezeAfter > 0 && contest.Duration <= 0 {
+		return ContestData{}, errors.New("FreezeAfter requires the contest to have a Duration")
+	}
+
+	return contest, nil
 }
 
 // Tasks returns an array []TaskData corresponding to the tasks stored inside
@@ -274,6 +389,7 @@ func (db *Database) Tests(name string, key []byte) ([]TestData, error) {
 		info := strings.Split(filepath.Base(file.Name), ".")
 		if info[1] == "in" {
 			ix, _ := strconv.Atoi(info[0])
+			tests[ix].N = ix
 			tests[ix].Input, err = db.readSecure(file, key)
 			if err != nil {
 				return []TestData{}, err
@@ -290,6 +406,58 @@ func (db *Database) Tests(name string, key []byte) ([]TestData, error) {
 	return tests, err
 }
 
+// Checker returns an array []FileData corresponding to all the files inside
+// the checker folder of the task with the specified name, stored inside the
+// database.
+func (db *Database) Checker(name string, key []byte) ([]FileData, error) {
+	return db.readFolder("/"+name+"/checker/", key)
+}
+
+// Interactor returns an array []FileData corresponding to all the files
+// inside the interactor folder of the task with the specified name, stored
+// inside the database.
+func (db *Database) Interactor(name string, key []byte) ([]FileData, error) {
+	return db.readFolder("/"+name+"/interactor/", key)
+}
+
+// Graders returns an array []FileData corresponding to all the files inside
+// the graders folder of the task with the specified name, stored inside the
+// database. These are compiled together with the submissions to the task, and
+// it's an error if their entry point can't be chosen.
+func (db *Database) Graders(name string, key []byte) ([]FileData, error) {
+	graders, err := db.readFolder("/"+name+"/graders/", key)
+	if err != nil {
+		return []FileData{}, err
+	}
+
+	names := make([]string, len(graders))
+	for i, grader := range graders {
+		names[i] = grader.Name
+	}
+
+	if err := checkGraders(name, names); err != nil {
+		return []FileData{}, err
+	}
+
+	return graders, nil
+}
+
+func (db *Database) readFolder(path string, key []byte) ([]FileData, error) {
+	files := db.filterFolder(path)
+	result := make([]FileData, len(files))
+
+	for i, file := range files {
+		content, err := db.readSecure(file, key)
+		if err != nil {
+			return []FileData{}, err
+		}
+
+		result[i] = FileData{Name: filepath.Base(file.Name), Content: content}
+	}
+
+	return result, nil
+}
+
 // BuildDatabase uses the files from the specified source folder to create a zip
 // database in the correct format at the specified target folder. It will
 // encrypt any sensitive files with the specified password, or ask for a new
@@ -348,6 +516,26 @@ func BuildDatabase(source, target string, password []byte, writePassword bool) e
 			return err
 		}
 
+		// the graders are checked here too, so that a task whose entry point
+		// can't be chosen is found before the contest starts
+		if info.IsDir() && info.Name() == "graders" && filepath.Dir(filepath.Dir(path)) == source {
+			entries, err := ioutil.ReadDir(path)
+			if err != nil {
+				return err
+			}
+
+			var names []string
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					names = append(names, entry.Name())
+				}
+			}
+
+			if err := checkGraders(filepath.Base(filepath.Dir(path)), names); err != nil {
+				return err
+			}
+		}
+
 		header, err := zip.FileInfoHeader(info)
 		if err != nil {
 			return err
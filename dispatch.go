@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// judging stores the state of a submission whose tests are being run. Tests
+// are split into jobs so that any idle worker can run them, after copying the
+// compiled submission (and the checker or interactor, if any) into its own
+// sandboxes.
+type judging struct {
+	submission Submission
+	executable string
+	program    []FileData
+	compare    Comparator
+	batches    []BatchData
+
+	helperLang       Language
+	helperExecutable string
+	helperFiles      []FileData
+
+	lock    sync.Mutex
+	failed  []bool
+	aborted bool
+
+	// done and total count the tests of the judging, for its progress
+	done  int
+	total int
+}
+
+// testJob is a single test of a judging, to be run by any worker.
+type testJob struct {
+	judging *judging
+	test    TestData
+	results chan<- testJobResult
+}
+
+type testJobResult struct {
+	verdict TestVerdict
+	err     error
+}
+
+// needed reports whether the result of a test may still change the verdict,
+// which is not the case once every batch it belongs to has failed, unless
+// the batch scores are summed or the submission asked for a full evaluation.
+func (j *judging) needed(n int) bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.aborted || j.submission.cancelled() {
+		return false
+	}
+
+	if j.submission.FullEvaluation {
+		return true
+	}
+
+	for batchNumber, batch := range j.batches {
+		if j.failed[batchNumber] && batch.Aggregation != AggregationSum {
+			continue
+		}
+
+		for _, i := range batch.Tests {
+			if i == n {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// report records the result of a test, marking the batches it belongs to as
+// failed when the test failed, or aborting the judging on errors.
+func (j *judging) report(verdict TestVerdict, err error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if err != nil {
+		j.aborted = true
+		return
+	}
+
+	if verdict.Result == ResultCorrect || verdict.Result == ResultPartial {
+		return
+	}
+
+	for batchNumber, batch := range j.batches {
+		for _, i := range batch.Tests {
+			if i == verdict.N {
+				j.failed[batchNumber] = true
+				break
+			}
+		}
+	}
+}
+
+// dispatch sends the tests of a judging to all the workers, running some of
+// them itself while waiting, and returns their results ordered by test
+// number. Tests that didn't need to run are left with ResultNothing.
+func (w *judgeWorker) dispatch(j *judging, tests []TestData) ([]TestVerdict, error) {
+	results := make(chan testJobResult, len(tests))
+
+	go func() {
+		for _, test := range tests {
+			w.testJobChannel <- testJob{judging: j, test: test, results: results}
+		}
+	}()
+
+	verdicts := make([]TestVerdict, len(tests))
+
+	// output-only submissions are only checked, never executed
+	state := ProgressRunning
+	if j.submission.Task.Type == TaskTypeOutputOnly {
+		state = ProgressChecking
+	}
+	j.lock.Lock()
+	j.total = len(tests)
+	j.lock.Unlock()
+	w.parent.publish(j.submission, state, 0, len(tests))
+
+	var err error
+	for received := 0; received < len(tests); {
+		select {
+		case job := <-w.testJobChannel:
+			w.runJob(job)
+		case result := <-results:
+			received++
+			j.lock.Lock()
+			j.done = received
+			j.lock.Unlock()
+			w.parent.publish(j.submission, state, received, len(tests))
+			if result.err != nil {
+				if err == nil {
+					err = result.err
+				}
+				continue
+			}
+			verdicts[result.verdict.N] = result.verdict
+		}
+	}
+
+	return verdicts, err
+}
+
+// runJob runs a single test of a judging, unless its result isn't needed
+// anymore, and sends the result back to the worker that dispatched it.
+func (w *judgeWorker) runJob(job testJob) {
+	j := job.judging
+
+	if !j.needed(job.test.N) {
+		job.results <- testJobResult{verdict: TestVerdict{N: job.test.N}}
+		return
+	}
+
+	verdict, err := w.runTest(j, job.test)
+	verdict.N = job.test.N
+
+	if testingFlag {
+		fmt.Printf("Worker %d, test %d: %+v\n", w.id, job.test.N, verdict)
+	}
+
+	j.report(verdict, err)
+	job.results <- testJobResult{verdict: verdict, err: err}
+}
+
+func (w *judgeWorker) runTest(j *judging, test TestData) (TestVerdict, error) {
+	err := w.load(j)
+	if err != nil {
+		return TestVerdict{}, err
+	}
+
+	s := j.submission
+
+	switch s.Task.Type {
+	case TaskTypeInteractive:
+		return w.interact(w.box, s, j.executable, test, w.helper)
+	case TaskTypeOutputOnly:
+		output, ok := s.Outputs[test.N]
+		if !ok {
+			return TestVerdict{Result: ResultWrong, Extra: "Missing output"}, nil
+		}
+		w.checking(j)
+		return checkOutput(output, test, j.compare, w.helper)
+	default:
+		return w.evaluate(w.box, s, j.executable, test, j.compare, w.helper, func() { w.checking(j) })
+	}
+}
+
+// checking reports that a test of a judging is being checked, as the checker
+// may take as long as running the submission. Comparators are quick, so
+// nothing is reported for tasks without a checker. The next test done reports
+// the judging as running again.
+func (w *judgeWorker) checking(j *judging) {
+	if w.helper == nil {
+		return
+	}
+
+	j.lock.Lock()
+	done, total := j.done, j.total
+	j.lock.Unlock()
+
+	w.parent.publish(j.submission, ProgressChecking, done, total)
+}
+
+// load copies the programs of a judging into the sandboxes of the worker,
+// unless they are already there.
+func (w *judgeWorker) load(j *judging) error {
+	if w.loaded == j {
+		return nil
+	}
+
+	w.unload()
+
+	if j.submission.Task.Type != TaskTypeOutputOnly {
+		box, err := w.prepare(0, j.submission.Lang, j.program)
+		if err != nil {
+			return err
+		}
+		w.box = box
+	}
+
+	if j.helperLang != nil {
+		box, err := w.prepare(1, j.helperLang, j.helperFiles)
+		if err != nil {
+			w.unload()
+			return err
+		}
+		w.helper = &taskProgram{box: box, lang: j.helperLang, executable: j.helperExecutable}
+	}
+
+	w.loaded = j
+	return nil
+}
+
+// unload clears the sandboxes holding the programs of the last judging loaded
+// by the worker.
+func (w *judgeWorker) unload() {
+	if w.box != nil {
+		w.box.Clear()
+		w.box = nil
+	}
+
+	if w.helper != nil {
+		w.helper.box.Clear()
+		w.helper = nil
+	}
+
+	w.loaded = nil
+}
+
+// boxFiles reads the files left inside a sandbox after compilation, so they
+// can be copied into the sandboxes of other workers.
+func boxFiles(box *Box) ([]FileData, error) {
+	root := filepath.Join(box.BoxPath, "box")
+
+	var files []FileData
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == "lost+found" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// hidden files hold outputs and messages of previous executions
+		if strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return errors.New("Unexpected file " + info.Name())
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, FileData{Name: name, Content: content, Mode: info.Mode()})
+		return nil
+	})
+
+	return files, err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// exportReport is a single line of the report included in exports.
+type exportReport struct {
+	TaskVerdict
+	Result string
+	Score  float64
+}
+
+// WriteExport writes a zip with everything a contestant did during the
+// contest: the code of every submission, the code left in the editor of every
+// task and a report of all the verdicts, in JSON and CSV. The verdicts must
+// already be stripped of any feedback the contestant can't see.
+func WriteExport(w io.Writer, verdicts []TaskVerdict, codes map[string]CodeInfo) error {
+	archive := zip.NewWriter(w)
+
+	for _, v := range verdicts {
+		if len(v.Code) == 0 {
+			continue
+		}
+
+		extension := ""
+		if lang := LanguageByName(v.LangName); lang != nil {
+			extension = lang.SourceExtension()
+		}
+
+		name := fmt.Sprintf("%d-%s%s", v.ID, strings.TrimPrefix(v.ResultKey(), "result_"), extension)
+		if err := writeExportFile(archive, path.Join("submissions", exportName(v.TaskName), name), []byte(v.Code)); err != nil {
+			return err
+		}
+	}
+
+	for task, code := range codes {
+		if len(code.Code) == 0 {
+			continue
+		}
+
+		extension := ""
+		if code.Lang >= 0 && code.Lang < len(AllLanguages) {
+			extension = AllLanguages[code.Lang].SourceExtension()
+		}
+
+		if err := writeExportFile(archive, path.Join("code", exportName(task)+extension), []byte(code.Code)); err != nil {
+			return err
+		}
+	}
+
+	sort.Sort(taskVerdictsByID(verdicts))
+
+	reports := make([]exportReport, len(verdicts))
+	for i, v := range verdicts {
+		reports[i] = exportReport{v, strings.TrimPrefix(v.ResultKey(), "result_"), v.Score()}
+	}
+
+	f, err := archive.Create("report.json")
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(reports); err != nil {
+		return err
+	}
+
+	f, err = archive.Create("report.csv")
+	if err != nil {
+		return err
+	}
+
+	if err := writeExportCSV(f, reports); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// writeExportCSV writes a line for each submission, with the score and
+// result of each of its batches in separate columns.
+func writeExportCSV(w io.Writer, reports []exportReport) error {
+	batches := 0
+	for _, r := range reports {
+		if len(r.Batches) > batches {
+			batches = len(r.Batches)
+		}
+	}
+
+	header := []string{"ID", "Task", "When", "Language", "Result", "Score"}
+	for i := 1; i <= batches; i++ {
+		header = append(header, "Batch "+strconv.Itoa(i)+" result", "Batch "+strconv.Itoa(i)+" score")
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range reports {
+		record := []string{
+			strconv.Itoa(int(r.ID)),
+			r.TaskName,
+			r.When.Format("2006-01-02 15:04:05"),
+			r.LangName,
+			r.Result,
+			strconv.FormatFloat(r.Score, 'f', -1, 64),
+		}
+
+		for _, batch := range r.Batches {
+			result := "correct"
+			if key, ok := resultKeys[batch.Result]; ok {
+				result = strings.TrimPrefix(key, "result_")
+			}
+			record = append(record, result, strconv.FormatFloat(batch.Score, 'f', -1, 64))
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeExportFile(archive *zip.Writer, name string, content []byte) error {
+	f, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(content)
+	return err
+}
+
+// exportName makes a task name safe to be used as a file name.
+func exportName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
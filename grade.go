@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// GradeResult is the verdict of a single source file judged from the command
+// line.
+type GradeResult struct {
+	File     string
+	Result   string
+	Score    float64
+	MaxScore float64
+	Verdict  TaskVerdict
+}
+
+// Accepted reports whether the file got the full score without errors.
+func (r GradeResult) Accepted() bool {
+	return r.Result == "correct"
+}
+
+// Grade judges each source file as a separate submission to the task, without
+// the web interface. The language of each file is lang, or the one guessed
+// from its extension if lang is nil.
+func Grade(judge *Judge, db *Database, key []byte, task TaskData, lang Language, files []string, full bool) ([]GradeResult, error) {
+	if task.Type == TaskTypeOutputOnly {
+		return nil, errors.New("Task " + task.Name + " is output-only, there is no code to judge")
+	}
+
+	submissions := make([]Submission, len(files))
+	for i, file := range files {
+		code, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		fileLang := lang
+		if fileLang == nil {
+			fileLang = LanguageByExtension(filepath.Ext(file))
+			if fileLang == nil {
+				return nil, errors.New("Can't guess the language of " + file + ", use -lang")
+			}
+		}
+
+		// each submission gets its own copy of the task, as they are judged
+		// in parallel
+		t := task
+		submissions[i] = Submission{
+			SID:            "grade",
+			When:           time.Now(),
+			Task:           &t,
+			Code:           code,
+			Lang:           fileLang,
+			DB:             db,
+			Key:            key,
+			FullEvaluation: full,
+		}
+	}
+
+	// the judge queue is bounded, so only part of the files wait in it at a
+	// time
+	byID := make(map[uint32]int)
+	results := make([]GradeResult, len(files))
+	sent, received := 0, 0
+
+	for received < len(files) {
+		for sent < len(files) && sent-received < queueLimit {
+			id, err := judge.SendSubmission(submissions[sent])
+			if err != nil {
+				return nil, err
+			}
+			byID[id] = sent
+			sent++
+		}
+
+		verdict := <-judge.TaskVerdictChannel
+		i, ok := byID[verdict.ID]
+		if !ok {
+			continue
+		}
+
+		results[i] = GradeResult{
+			File:     files[i],
+			Result:   strings.TrimPrefix(verdict.ResultKey(), "result_"),
+			Score:    verdict.Score(),
+			MaxScore: task.MaxScore(),
+			Verdict:  verdict,
+		}
+		received++
+	}
+
+	return results, nil
+}
+
+// gradeFiles expands the directories among paths into the source files
+// directly inside them, those of lang or of any known language if lang is
+// nil.
+func gradeFiles(paths []string, lang Language) ([]string, error) {
+	var files []string
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+
+		found := 0
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+
+			ext := filepath.Ext(entry.Name())
+			if (lang != nil && ext == lang.SourceExtension()) || (lang == nil && LanguageByExtension(ext) != nil) {
+				files = append(files, filepath.Join(path, entry.Name()))
+				found++
+			}
+		}
+
+		if found == 0 {
+			return nil, errors.New("No source files found in " + path)
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, errors.New("No source files to judge")
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// WriteGradeTable prints a line for each judged file, with its overall result
+// and score followed by the result and score of each batch.
+func WriteGradeTable(w io.Writer, results []GradeResult) error {
+	batches := 0
+	for _, r := range results {
+		if len(r.Verdict.Batches) > batches {
+			batches = len(r.Verdict.Batches)
+		}
+	}
+
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprint(table, "FILE\tRESULT\tSCORE")
+	for i := 1; i <= batches; i++ {
+		fmt.Fprint(table, "\tBATCH "+strconv.Itoa(i))
+	}
+	fmt.Fprintln(table)
+
+	for _, r := range results {
+		fmt.Fprintf(table, "%s\t%s\t%s/%s", r.File, r.Result,
+			strconv.FormatFloat(r.Score, 'f', -1, 64), strconv.FormatFloat(r.MaxScore, 'f', -1, 64))
+
+		for _, batch := range r.Verdict.Batches {
+			result := "correct"
+			if key, ok := resultKeys[batch.Result]; ok {
+				result = strings.TrimPrefix(key, "result_")
+			}
+			fmt.Fprintf(table, "\t%s %s", result, strconv.FormatFloat(batch.Score, 'f', -1, 64))
+		}
+		fmt.Fprintln(table)
+	}
+
+	return table.Flush()
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	maxCodeSnapshots     = 50
+	maxCodeSubmissions   = 100
+	codeSnapshotInterval = time.Minute
+
+	// maxDiffEdits bounds the work done comparing two versions; codes that
+	// differ more than that are shown as completely replaced.
+	maxDiffEdits = 1000
+)
+
+// CodeVersion is a copy of the code of a task, either saved automatically
+// while it was being edited or sent as a submission.
+type CodeVersion struct {
+	ID         int
+	When       time.Time
+	Code       string
+	Lang       int
+	Submission uint32 // 0 for automatic snapshots
+}
+
+// Kinds of DiffLine
+const (
+	DiffEqual  = "="
+	DiffInsert = "+"
+	DiffDelete = "-"
+)
+
+// DiffLine is a single line of the difference between two codes.
+type DiffLine struct {
+	Kind string
+	Text string
+}
+
+// addCodeVersion appends a version to a history, dropping the oldest
+// snapshots or submissions once there are too many of them.
+func addCodeVersion(history []CodeVersion, version CodeVersion) []CodeVersion {
+	version.ID = 1
+	if len(history) > 0 {
+		version.ID = history[len(history)-1].ID + 1
+	}
+	history = append(history, version)
+
+	limit := maxCodeSnapshots
+	if version.Submission != 0 {
+		limit = maxCodeSubmissions
+	}
+
+	count := 0
+	for _, v := range history {
+		if (v.Submission != 0) == (version.Submission != 0) {
+			count++
+		}
+	}
+
+	for i := 0; count > limit; i++ {
+		if (history[i].Submission != 0) == (version.Submission != 0) {
+			history = append(history[:i], history[i+1:]...)
+			count--
+			i--
+		}
+	}
+
+	return history
+}
+
+// needsSnapshot reports whether the code about to be replaced should be kept
+// in the history: either the last snapshot is old enough, or most of the code
+// is about to disappear, which usually means an accident.
+func needsSnapshot(history []CodeVersion, old, code CodeInfo, now time.Time) bool {
+	if len(old.Code) == 0 || old.Code == code.Code {
+		return false
+	}
+
+	if len(code.Code) < len(old.Code)/2 {
+		return true
+	}
+
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Submission == 0 {
+			return now.Sub(history[i].When) >= codeSnapshotInterval
+		}
+	}
+
+	return true
+}
+
+// Diff returns the line by line difference between two codes.
+func Diff(a, b string) []DiffLine {
+	aLines := codeLines(a)
+	bLines := codeLines(b)
+
+	prefix := 0
+	for prefix < len(aLines) && prefix < len(bLines) && aLines[prefix] == bLines[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(aLines)-prefix && suffix < len(bLines)-prefix &&
+		aLines[len(aLines)-1-suffix] == bLines[len(bLines)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]DiffLine, 0, len(aLines)+len(bLines))
+	for _, line := range aLines[:prefix] {
+		lines = append(lines, DiffLine{DiffEqual, line})
+	}
+
+	lines = append(lines, diffMiddle(aLines[prefix:len(aLines)-suffix], bLines[prefix:len(bLines)-suffix])...)
+
+	for _, line := range aLines[len(aLines)-suffix:] {
+		lines = append(lines, DiffLine{DiffEqual, line})
+	}
+
+	return lines
+}
+
+func codeLines(code string) []string {
+	if len(code) == 0 {
+		return nil
+	}
+	code = strings.Replace(code, "\r\n", "\n", -1)
+	return strings.Split(strings.TrimSuffix(code, "\n"), "\n")
+}
+
+// diffMiddle finds the shortest edit script between two lists of lines using
+// Myers' algorithm, replacing all of them when it's longer than maxDiffEdits.
+func diffMiddle(a, b []string) []DiffLine {
+	n, m := len(a), len(b)
+	max := n + m
+	if max > maxDiffEdits {
+		max = maxDiffEdits
+	}
+
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+	for d := 0; d <= max; d++ {
+		// only the diagonals from -d-1 to d+1 are read while backtracking
+		// step d, so the memory used grows with the square of the edits and
+		// not with their product by the length of the codes
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrackDiff(a, b, trace)
+			}
+		}
+	}
+
+	lines := make([]DiffLine, 0, n+m)
+	for _, line := range a {
+		lines = append(lines, DiffLine{DiffDelete, line})
+	}
+	for _, line := range b {
+		lines = append(lines, DiffLine{DiffInsert, line})
+	}
+	return lines
+}
+
+// backtrackDiff walks the states saved by diffMiddle from the end, building
+// the edit script in reverse. The state of step d holds the diagonals from
+// -d-1 to d+1.
+func backtrackDiff(a, b []string, trace [][]int) []DiffLine {
+	var lines []DiffLine
+
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		offset := d + 1
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			lines = append(lines, DiffLine{DiffEqual, a[x-1]})
+			x--
+			y--
+		}
+
+		if d > 0 {
+			if x == prevX {
+				lines = append(lines, DiffLine{DiffInsert, b[y-1]})
+			} else {
+				lines = append(lines, DiffLine{DiffDelete, a[x-1]})
+			}
+		}
+
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+
+	return lines
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// interact runs the submission over a single test of an interactive task, with
+// its standard input and output cross-connected to the ones of the task
+// interactor, which runs at the same time in its own sandbox and decides the
+// test result through its exit code.
+func (w *judgeWorker) interact(box *Box, s Submission, executable string, test TestData, interactor *taskProgram) (TestVerdict, error) {
+	var ret TestVerdict
+
+	err := interactor.writeTest(test, nil)
+	if err != nil {
+		return ret, err
+	}
+
+	messageFile, err := os.Create(filepath.Join(interactor.box.BoxPath, "box", ".message"))
+	if err != nil {
+		return ret, err
+	}
+
+	contestantConfig := submissionConfig(s, executable)
+
+	interactorConfig := interactor.config([]string{"input", "output", "answer"})
+	interactorConfig.Stderr = messageFile
+	interactorConfig.WallTimeLimit += contestantConfig.WallTimeLimit
+	interactorConfig.Cancel = s.cancel
+
+	if err := contestantConfig.PipeStdout(interactorConfig); err != nil {
+		messageFile.Close()
+		return ret, err
+	}
+
+	if err := interactorConfig.PipeStdout(contestantConfig); err != nil {
+		messageFile.Close()
+		return ret, err
+	}
+
+	contestantChannel := make(chan *BoxResult)
+	go func() {
+		contestantChannel <- box.Run(contestantConfig)
+	}()
+
+	interactorResult := interactor.box.Run(interactorConfig)
+	contestantResult := <-contestantChannel
+	messageFile.Close()
+
+	if contestantResult.Status == StatusError {
+		return ret, errors.New(contestantResult.Error)
+	}
+
+	ret.setStatus(contestantResult)
+
+	code, score, extra, err := interactor.verdict(interactorResult)
+
+	// The submission is usually killed by SIGPIPE when the interactor gives
+	// up early on a wrong answer, in which case the interactor verdict is
+	// the meaningful one. Otherwise, a failed execution of the submission
+	// takes precedence over whatever the interactor has to say.
+	brokenPipe := contestantResult.Status == StatusSig && contestantResult.Signal == syscall.SIGPIPE
+	if ret.Result != ResultCorrect && !(brokenPipe && err == nil && code == ResultWrong) {
+		return ret, nil
+	}
+
+	if err != nil {
+		return ret, errors.New("Interactor: " + err.Error())
+	}
+
+	ret.Result, ret.Score, ret.Extra = code, score, extra
+	return ret, nil
+}
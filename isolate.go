@@ -32,7 +32,7 @@ const (
 	boxFirstUID  = 60000
 	boxFirstGID  = 60000
 	boxRoot      = "/obibox"
-	boxNumLimit  = 2
+	boxNumLimit  = 24
 	boxImageSize = 10 << 20 // 10 MB
 
 	errChildFailed = 42
@@ -71,6 +71,13 @@ const (
 	// StatusExit means the program exited with an error code.
 	StatusExit
 
+	// StatusOLE means the Output Limit was exceeded.
+	StatusOLE
+
+	// StatusMLE means the Memory Limit was exceeded, according to the memory
+	// control group, and the program was killed or failed because of that.
+	StatusMLE
+
 	// StatusError means an error has occurred in the sandbox.
 	StatusError
 )
@@ -136,8 +143,12 @@ type BoxConfig struct {
 	WallTimeLimit time.Duration
 	// Limit memory usage in KB
 	MemoryLimit int64
+	// Limit size of written files in KB
+	OutputLimit int64
 	// Maximum number of processes
 	MaxProcesses int
+	// Kill the execution once closed
+	Cancel <-chan struct{}
 
 	boxPath   string
 	boxUID    int
@@ -148,6 +159,7 @@ type BoxConfig struct {
 	errorPipe *os.File
 	startTime time.Time
 	result    *BoxResult
+	memoryHit bool
 
 	childFiles      []*os.File
 	closeAfterStart []io.Closer
@@ -414,6 +426,17 @@ func (c *BoxConfig) updateResult(rusage *unix.Rusage) {
 			if int64(stats.Memory.Swap.Usage>>10) > c.result.Memory {
 				c.result.Memory = int64(stats.Memory.Swap.Max >> 10)
 			}
+
+			// memory.failcnt counts the times the limit was reached, while
+			// oom_kill counts the processes killed because of that
+			if c.MemoryLimit != 0 {
+				if stats.Memory.Usage.Failcnt > 0 || stats.Memory.Swap.Failcnt > 0 {
+					c.memoryHit = true
+				}
+				if stats.MemoryOomControl != nil && stats.MemoryOomControl.OomKill > 0 {
+					c.memoryHit = true
+				}
+			}
 			return
 		}
 	}
@@ -488,11 +511,19 @@ func (c *BoxConfig) runParent() error {
 				c.result.ExitCode = result.stat.ExitStatus()
 				if c.result.ExitCode != 0 {
 					c.result.Status = StatusExit
+					if c.memoryHit {
+						c.result.Status = StatusMLE
+					}
 				}
 				return nil
 			} else if result.stat.Signaled() {
 				c.result.Signal = result.stat.Signal()
 				c.result.Status = StatusSig
+				if c.OutputLimit != 0 && c.result.Signal == unix.SIGXFSZ {
+					c.result.Status = StatusOLE
+				} else if c.memoryHit {
+					c.result.Status = StatusMLE
+				}
 				return nil
 			} else if result.stat.Stopped() {
 				c.result.Signal = result.stat.StopSignal()
@@ -505,17 +536,24 @@ func (c *BoxConfig) runParent() error {
 		case err := <-waiterrch:
 			return c.end(err)
 
+		case <-c.Cancel:
+			return c.end(errors.New("Execution cancelled"))
+
 		default:
 			c.updateResult(nil)
-			if c.WallTimeLimit != 0 && c.result.WallTime > c.WallTimeLimit {
-				c.result.Status = StatusWTL
-				return c.end(nil)
-			}
 
+			// the CPU time is checked first, so the wall time limit is only
+			// reported for programs that spent it waiting, such as sleeping
+			// or blocked reading
 			if c.CPUTimeLimit != 0 && c.result.CPUTime > c.CPUTimeLimit {
 				c.result.Status = StatusCTL
 				return c.end(nil)
 			}
+
+			if c.WallTimeLimit != 0 && c.result.WallTime > c.WallTimeLimit {
+				c.result.Status = StatusWTL
+				return c.end(nil)
+			}
 		}
 	}
 
@@ -609,13 +647,22 @@ func (c *BoxConfig) setupRoot() error {
 // setupRlimits is used to configure Rlimits inside the child process. It
 // should run inside the child process, before executing the program.
 func (c *BoxConfig) setupRlimits() error {
-	if c.MemoryLimit != 0 {
+	// With control groups, the memory limit is enforced by the memory
+	// controller instead, which allows telling when it was exceeded
+	if c.MemoryLimit != 0 && !c.EnableCgroups {
 		memlimit := uint64(c.MemoryLimit) << 10
 		if err := unix.Setrlimit(unix.RLIMIT_AS, &unix.Rlimit{Cur: memlimit, Max: memlimit}); err != nil {
 			return err
 		}
 	}
 
+	if c.OutputLimit != 0 {
+		outputlimit := uint64(c.OutputLimit) << 10
+		if err := unix.Setrlimit(unix.RLIMIT_FSIZE, &unix.Rlimit{Cur: outputlimit, Max: outputlimit}); err != nil {
+			return err
+		}
+	}
+
 	if err := unix.Setrlimit(unix.RLIMIT_STACK, &unix.Rlimit{Cur: unix.RLIM_INFINITY, Max: unix.RLIM_INFINITY}); err != nil {
 		return err
 	}
@@ -862,6 +909,28 @@ func (c *BoxConfig) StdinPipe() (io.WriteCloser, error) {
 	return wc, nil
 }
 
+// PipeStdout connects the standard output of the command to the standard
+// input of another command, both running in their own sandboxes. Each end of
+// the pipe is closed in the parent as soon as the command using it starts, so
+// both commands see end-of-file once the other one exits.
+func (c *BoxConfig) PipeStdout(other *BoxConfig) error {
+	if c.Stdout != nil {
+		return errors.New("exec: Stdout already set")
+	}
+	if other.Stdin != nil {
+		return errors.New("exec: Stdin already set")
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	c.Stdout = pw
+	c.closeAfterStart = append(c.closeAfterStart, pw)
+	other.Stdin = pr
+	other.closeAfterStart = append(other.closeAfterStart, pr)
+	return nil
+}
+
 type closeOnce struct {
 	*os.File
 
@@ -2,12 +2,14 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -16,11 +18,12 @@ const (
 	// ResultNothing means no result has been attributed yet.
 	ResultNothing int = iota
 
-	// ResultTimeout means the program execution has timed-out.
+	// ResultTimeout means the program execution has exceeded its CPU time
+	// limit.
 	ResultTimeout
 
 	// ResultSignal means the program has been signaled, probably due to a
-	// violation to the memory limits.
+	// runtime error.
 	ResultSignal
 
 	// ResultFailed means the program has failed executing and returned a
@@ -33,6 +36,21 @@ const (
 
 	// ResultWrong means the program output was not correct.
 	ResultWrong
+
+	// ResultPartial means the program output was only partially correct,
+	// according to the task checker.
+	ResultPartial
+
+	// ResultWallTimeout means the program execution has exceeded its wall
+	// time limit, usually while sleeping or waiting for input.
+	ResultWallTimeout
+
+	// ResultOutputLimit means the program has written more than the task
+	// output limit.
+	ResultOutputLimit
+
+	// ResultMemoryLimit means the program has exceeded the task memory limit.
+	ResultMemoryLimit
 )
 
 const (
@@ -55,9 +73,12 @@ const (
 )
 
 const (
-	numWorkers = 2
-	envHOME    = "HOME=/box"
-	envPATH    = "PATH=/usr/bin:/usr/local/bin:/box"
+	numWorkers         = 2
+	boxesPerWorker     = 4
+	defaultOutputLimit = 1 << 12 // 4MB, as the sandbox image only has 10MB
+	defaultBatchValue  = 100
+	envHOME            = "HOME=/box"
+	envPATH            = "PATH=/usr/bin:/usr/local/bin:/box"
 )
 
 var (
@@ -74,6 +95,31 @@ type Submission struct {
 	Lang Language
 	DB   *Database
 	Key  []byte
+
+	// Elapsed is the time since the start of the contest when the submission
+	// was sent.
+	Elapsed time.Duration
+
+	// Outputs maps each test number to its uploaded output, for output-only
+	// tasks, in which case Code and Lang are not used.
+	Outputs map[int][]byte
+
+	// FullEvaluation runs every test, even the ones of batches which have
+	// already failed.
+	FullEvaluation bool
+
+	// cancel is closed when the submission is cancelled
+	cancel chan struct{}
+}
+
+// cancelled reports whether the submission was cancelled through Judge.Cancel.
+func (s *Submission) cancelled() bool {
+	select {
+	case <-s.cancel:
+		return true
+	default:
+		return false
+	}
 }
 
 // CustomTest stores information related to custom test requested by the user.
@@ -85,6 +131,8 @@ type CustomTest struct {
 	Input    []byte
 	Code     []byte
 	Lang     Language
+	DB       *Database
+	Key      []byte
 }
 
 // TaskVerdict is used to indicate the verdict of a user submission.
@@ -92,6 +140,10 @@ type TaskVerdict struct {
 	VerdictInfo
 	Compilation int
 	Batches     []BatchVerdict
+	Tests       []TestVerdict
+	Elapsed     time.Duration
+	Cancelled   bool
+	Frozen      bool
 	Error       bool
 	Extra       string
 }
@@ -99,10 +151,114 @@ type TaskVerdict struct {
 // BatchVerdict is used to indicate the verdict of a single batch from the task.
 type BatchVerdict struct {
 	Result int
-	Score  int
+	Score  float64
 	Time   time.Duration
 	Memory int64
 	Extra  string
+	Tests  []int
+}
+
+// TestVerdict is used to indicate the verdict of a single test from the task.
+// Score is the fraction of the test score obtained, and Extra usually holds
+// the checker message.
+type TestVerdict struct {
+	N        int
+	Result   int
+	Score    float64
+	Time     time.Duration
+	WallTime time.Duration
+	Memory   int64
+	ExitCode int
+	Signal   string
+	Extra    string
+}
+
+// WithFeedback returns a copy of the verdict revealing only the amount of
+// per-test information allowed by the specified feedback level. Levels other
+// than FeedbackResults and FeedbackFull, including none at all, reveal no
+// per-test information.
+func (v TaskVerdict) WithFeedback(feedback string) TaskVerdict {
+	switch feedback {
+	case FeedbackFull:
+	case FeedbackResults:
+		tests := make([]TestVerdict, len(v.Tests))
+		for i, test := range v.Tests {
+			tests[i] = TestVerdict{N: test.N, Result: test.Result}
+		}
+		v.Tests = tests
+	default:
+		v.Tests = nil
+		batches := make([]BatchVerdict, len(v.Batches))
+		for i, batch := range v.Batches {
+			batch.Tests = nil
+			batches[i] = batch
+		}
+		v.Batches = batches
+	}
+
+	return v
+}
+
+// WithFreeze returns a copy of the verdict hiding its results, as those of the
+// submissions sent after the freeze are hidden until the contest ends.
+func (v TaskVerdict) WithFreeze() TaskVerdict {
+	return TaskVerdict{
+		VerdictInfo: v.VerdictInfo,
+		Elapsed:     v.Elapsed,
+		Frozen:      true,
+	}
+}
+
+// Score returns the total score of the submission, summed over its batches.
+func (v TaskVerdict) Score() float64 {
+	score := 0.0
+	for _, batch := range v.Batches {
+		score += batch.Score
+	}
+	return score
+}
+
+// ResultKey returns the localization key of the overall result of the
+// submission: the compilation result when it didn't compile, or the result
+// of the first batch which wasn't correct.
+func (v TaskVerdict) ResultKey() string {
+	if v.Error {
+		return "error"
+	} else if v.Cancelled {
+		return "result_cancelled"
+	} else if v.Frozen {
+		return "result_frozen"
+	}
+
+	switch v.Compilation {
+	case ResultCompTimeout:
+		return "result_comp_timeout"
+	case ResultCompSignal:
+		return "result_comp_signal"
+	case ResultCompFailed:
+		return "result_comp_failed"
+	}
+
+	for _, batch := range v.Batches {
+		if key, ok := resultKeys[batch.Result]; ok {
+			return key
+		}
+	}
+
+	return "result_correct"
+}
+
+// resultKeys holds the localization keys of the results other than
+// ResultCorrect and ResultNothing, which are shown as correct.
+var resultKeys = map[int]string{
+	ResultTimeout:     "result_timeout",
+	ResultSignal:      "result_signal",
+	ResultFailed:      "result_failed",
+	ResultWrong:       "result_wrong",
+	ResultPartial:     "result_partial",
+	ResultWallTimeout: "result_wall_timeout",
+	ResultOutputLimit: "result_output_limit",
+	ResultMemoryLimit: "result_memory_limit",
 }
 
 // CustomTestVerdict is used to indicate the verdict of a custom test requested
@@ -129,38 +285,91 @@ type VerdictInfo struct {
 	LangName string
 }
 
+// Progress states of a submission which is not judged yet
+const (
+	ProgressQueued    = "queued"
+	ProgressCompiling = "compiling"
+	ProgressRunning   = "running"
+	ProgressChecking  = "checking"
+)
+
+// SubmissionProgress is used to indicate the state of a submission while it
+// is waiting or being judged. Position is its place in the judge queue, while
+// queued, and Tests is the number of tests of the task, of which Done have
+// already finished.
+type SubmissionProgress struct {
+	ID       uint32
+	SID      string
+	TaskName string
+	State    string
+	Position int
+	Done     int
+	Tests    int
+}
+
 // Judge stores information related to a single Judge instance.
 type Judge struct {
 	NumWorkers         int
-	SubmissionChannel  chan<- Submission
 	TaskVerdictChannel <-chan TaskVerdict
-	TestChannel        chan<- CustomTest
 	TestVerdictChannel <-chan CustomTestVerdict
+	ProgressChannel    <-chan SubmissionProgress
+
+	StressVerdictChannel <-chan StressVerdict
+
+	subID    uint32
+	testID   uint32
+	stressID uint32
+	workers  []*judgeWorker
+	queue    *judgeQueue
 
-	subID   uint32
-	testID  uint32
-	workers []*judgeWorker
+	progressChannel    chan SubmissionProgress
+	taskVerdictChannel chan<- TaskVerdict
+
+	// submissions stores every submission received, so they can be judged
+	// again, while pending stores the ones still queued or being judged
+	submissions map[uint32]Submission
+	pending     map[uint32]Submission
+	lock        sync.Mutex
+}
+
+// CheckWorkers returns an error if a judge instance can't have n workers, as
+// each of them uses its own sandboxes and there's a limited number of those.
+func CheckWorkers(n int) error {
+	if n < 1 {
+		return errors.New("At least one judge worker is required")
+	} else if n*boxesPerWorker > boxNumLimit {
+		return errors.New("At most " + strconv.Itoa(boxNumLimit/boxesPerWorker) + " judge workers are supported")
+	}
+	return nil
 }
 
 // Start is used to initialize a judge instance, like a constructor.
 func (j *Judge) Start() {
-	submissionChannel := make(chan Submission, 100)
 	taskVerdictChannel := make(chan TaskVerdict, 100)
-	testChannel := make(chan CustomTest, 100)
 	testVerdictChannel := make(chan CustomTestVerdict, 100)
+	stressVerdictChannel := make(chan StressVerdict, 100)
+	testJobChannel := make(chan testJob)
+
+	j.progressChannel = make(chan SubmissionProgress, 100)
 
-	j.SubmissionChannel = submissionChannel
 	j.TaskVerdictChannel = taskVerdictChannel
-	j.TestChannel = testChannel
+	j.taskVerdictChannel = taskVerdictChannel
 	j.TestVerdictChannel = testVerdictChannel
+	j.StressVerdictChannel = stressVerdictChannel
+	j.ProgressChannel = j.progressChannel
+	j.queue = newJudgeQueue(queueLimit)
+	j.submissions = make(map[uint32]Submission)
+	j.pending = make(map[uint32]Submission)
 
 	for id := 0; id < j.NumWorkers; id++ {
 		worker := &judgeWorker{
-			id:                 id,
-			submissionChannel:  submissionChannel,
-			taskVerdictChannel: taskVerdictChannel,
-			testChannel:        testChannel,
-			testVerdictChannel: testVerdictChannel,
+			id:                   id,
+			queue:                j.queue,
+			taskVerdictChannel:   taskVerdictChannel,
+			testVerdictChannel:   testVerdictChannel,
+			stressVerdictChannel: stressVerdictChannel,
+			testJobChannel:       testJobChannel,
+			parent:               j,
 		}
 
 		j.workers = append(j.workers, worker)
@@ -175,103 +384,511 @@ func (j *Judge) Stop() {
 	for _, worker := range j.workers {
 		worker.stop()
 	}
-
-	close(j.SubmissionChannel)
-	close(j.TestChannel)
 }
 
 // SendSubmission is used to request that a judge instance receives a
-// user submission.
-func (j *Judge) SendSubmission(s Submission) uint32 {
+// user submission. It fails with ErrQueueFull if there are too many items
+// waiting to be judged.
+func (j *Judge) SendSubmission(s Submission) (uint32, error) {
 	s.ID = atomic.AddUint32(&j.subID, 1)
-	j.SubmissionChannel <- s
-	return s.ID
+	if err := j.enqueue(s); err != nil {
+		return 0, err
+	}
+	return s.ID, nil
+}
+
+func (j *Judge) enqueue(s Submission) error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	return j.enqueueLocked(s)
+}
+
+// enqueueLocked adds a submission to the queue. It should be called with the
+// judge lock held.
+func (j *Judge) enqueueLocked(s Submission) error {
+	s.cancel = make(chan struct{})
+
+	// the first submission of a session to a task goes ahead of the
+	// re-submissions, which are usually small fixes
+	priority := priorityFirstSubmission
+	for _, other := range j.submissions {
+		if other.ID != s.ID && other.SID == s.SID && other.Task.Name == s.Task.Name {
+			priority = prioritySubmission
+			break
+		}
+	}
+
+	if err := j.queue.push(&queueItem{submission: &s, priority: priority}); err != nil {
+		return err
+	}
+
+	j.submissions[s.ID] = s
+	j.pending[s.ID] = s
+
+	j.publish(s, ProgressQueued, 0, 0)
+	return nil
+}
+
+// publish reports the progress of a submission. Progress is only
+// informative, so it is dropped instead of blocking the judge when nobody is
+// listening.
+func (j *Judge) publish(s Submission, state string, done, tests int) {
+	progress := SubmissionProgress{
+		ID:       s.ID,
+		SID:      s.SID,
+		TaskName: s.Task.Name,
+		State:    state,
+		Done:     done,
+		Tests:    tests,
+	}
+
+	select {
+	case j.progressChannel <- progress:
+	default:
+	}
+}
+
+// Restore registers a submission judged before the program restarted, so it
+// can be judged again and its ID isn't reused.
+func (j *Judge) Restore(s Submission) {
+	j.lock.Lock()
+	j.submissions[s.ID] = s
+	j.lock.Unlock()
+
+	reserveID(&j.subID, s.ID)
+}
+
+// Requeue judges a submission which was waiting or being judged when the
+// program stopped, keeping its ID.
+func (j *Judge) Requeue(s Submission) error {
+	reserveID(&j.subID, s.ID)
+	return j.enqueue(s)
+}
+
+// RestoreCustomTest keeps the ID of a custom test run before the program
+// restarted from being reused.
+func (j *Judge) RestoreCustomTest(t CustomTest) {
+	reserveID(&j.testID, t.ID)
+}
+
+// reserveID makes sure the counter is at least id, so the next IDs are
+// greater than it.
+func reserveID(counter *uint32, id uint32) {
+	for {
+		current := atomic.LoadUint32(counter)
+		if current >= id || atomic.CompareAndSwapUint32(counter, current, id) {
+			return
+		}
+	}
+}
+
+// Position returns the position of a submission in the judge queue, starting
+// at 1, or 0 if it isn't waiting to be judged.
+func (j *Judge) Position(id uint32) int {
+	return j.queue.position(id)
+}
+
+// finish is called by the workers once a submission has been judged. It
+// reports whether the verdict should be published, which isn't the case when
+// the submission was cancelled and is already being judged again.
+func (j *Judge) finish(s Submission) bool {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	pending, ok := j.pending[s.ID]
+	if !ok {
+		return true
+	} else if pending.cancel == s.cancel {
+		delete(j.pending, s.ID)
+		return true
+	}
+	return false
+}
+
+// Submission returns a submission previously received by the judge instance.
+func (j *Judge) Submission(id uint32) (Submission, bool) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	s, ok := j.submissions[id]
+	return s, ok
+}
+
+// Cancel aborts a submission which is still queued or being judged, killing
+// its running programs. Its verdict is then reported as cancelled.
+func (j *Judge) Cancel(id uint32) error {
+	j.lock.Lock()
+
+	s, ok := j.pending[id]
+	if !ok {
+		j.lock.Unlock()
+		return errors.New("Submission " + strconv.Itoa(int(id)) + " is not being judged")
+	}
+
+	close(s.cancel)
+	delete(j.pending, id)
+
+	// a submission still queued no longer takes a place in the queue, and
+	// no worker will report its verdict
+	queued := j.queue.remove(id)
+	j.lock.Unlock()
+
+	if queued {
+		j.taskVerdictChannel <- withSubmission(TaskVerdict{Cancelled: true}, s)
+	}
+	return nil
+}
+
+// Rejudge judges a previously received submission again, against the
+// current version of its task in the database. The new verdict keeps the
+// submission ID.
+func (j *Judge) Rejudge(id uint32, db *Database) error {
+	j.lock.Lock()
+	s, ok := j.submissions[id]
+	j.lock.Unlock()
+
+	if !ok {
+		return errors.New("Submission " + strconv.Itoa(int(id)) + " doesn't exist")
+	} else if s.Lang == nil && s.Outputs == nil {
+		// outputs aren't kept across restarts
+		return errors.New("Submission " + strconv.Itoa(int(id)) + " can't be judged again")
+	}
+
+	// the contest database may have been reloaded since
+	if db != nil {
+		s.DB = db
+	}
+
+	task, err := s.DB.Task(s.Task.Name)
+	if err != nil {
+		return err
+	}
+	s.Task = &task
+
+	// the submission is checked and queued at once, so it's never queued
+	// twice by simultaneous requests
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if _, pending := j.pending[id]; pending {
+		return errors.New("Submission " + strconv.Itoa(int(id)) + " is still being judged")
+	}
+
+	return j.enqueueLocked(s)
 }
 
 // SendCustomTest is used to request that a judge instance receives a
-// custom test requested by the user.
-func (j *Judge) SendCustomTest(t CustomTest) uint32 {
+// custom test requested by the user. It fails with ErrQueueFull if there are
+// too many items waiting to be judged.
+func (j *Judge) SendCustomTest(t CustomTest) (uint32, error) {
 	t.ID = atomic.AddUint32(&j.testID, 1)
-	j.TestChannel <- t
-	return t.ID
+	if err := j.queue.push(&queueItem{test: &t, priority: priorityCustomTest}); err != nil {
+		return 0, err
+	}
+	return t.ID, nil
 }
 
 // judgeWorkers are simultaneous judging units of a single judge instance.
 type judgeWorker struct {
 	id                 int
-	submissionChannel  <-chan Submission
+	queue              *judgeQueue
 	taskVerdictChannel chan<- TaskVerdict
-	testChannel        <-chan CustomTest
 	testVerdictChannel chan<- CustomTestVerdict
+	testJobChannel     chan testJob
 	stopChannel        chan bool
+	parent             *Judge
+
+	stressVerdictChannel chan<- StressVerdict
+
+	// sandboxes holding the programs of the last judging whose tests were
+	// run by this worker
+	loaded *judging
+	box    *Box
+	helper *taskProgram
+
+	status     WorkerStatus
+	statusLock sync.Mutex
+}
+
+// States of the judge workers
+const (
+	WorkerIdle       = "idle"
+	WorkerSubmission = "submission"
+	WorkerCustomTest = "test"
+	WorkerStressTest = "stress"
+
+	// WorkerJob means the worker is running a test of a submission judged by
+	// another worker.
+	WorkerJob = "job"
+)
+
+// WorkerStatus describes what a judge worker is doing. ID and Task identify
+// the submission or custom test it's working on, unless it's idle.
+type WorkerStatus struct {
+	Worker int
+	State  string
+	ID     uint32
+	Task   string
+	Since  time.Time
+}
+
+func (w *judgeWorker) setState(state string, id uint32, task string) {
+	w.statusLock.Lock()
+	defer w.statusLock.Unlock()
+
+	w.status = WorkerStatus{Worker: w.id, State: state, ID: id, Task: task, Since: time.Now()}
+}
+
+// Workers returns the status of every judge worker.
+func (j *Judge) Workers() []WorkerStatus {
+	ret := make([]WorkerStatus, len(j.workers))
+	for i, w := range j.workers {
+		w.statusLock.Lock()
+		ret[i] = w.status
+		w.statusLock.Unlock()
+	}
+	return ret
+}
+
+// Queue returns the items waiting to be judged, in the order they will be.
+func (j *Judge) Queue() []QueueEntry {
+	return j.queue.list()
 }
 
 func (w *judgeWorker) start() {
 	w.stopChannel = make(chan bool)
+	w.setState(WorkerIdle, 0, "")
 	go func() {
+		defer w.unload()
+
 		for {
+			// tests of submissions already being judged come first
+			select {
+			case job := <-w.testJobChannel:
+				w.runOuterJob(job)
+				continue
+			default:
+			}
+
 			select {
 			case <-w.stopChannel:
 				return
-			case s := <-w.submissionChannel:
-				verdict := w.judge(s)
-
-				verdict.ID = s.ID
-				verdict.SID = s.SID
-				verdict.When = s.When
-				verdict.TaskName = s.Task.Name
-				verdict.Code = string(s.Code)
-				verdict.LangMime = s.Lang.MimeType()
-				verdict.LangName = s.Lang.Name()
-
-				if testingFlag {
-					fmt.Printf("%+v\n\n", verdict)
-				}
-				w.taskVerdictChannel <- verdict
-			case t := <-w.testChannel:
-				verdict := w.test(t)
-
-				verdict.ID = t.ID
-				verdict.SID = t.SID
-				verdict.When = t.When
-				verdict.TaskName = "_test"
-				verdict.Code = string(t.Code)
-				verdict.LangMime = t.Lang.MimeType()
-				verdict.LangName = t.Lang.Name()
-
-				if testingFlag {
-					fmt.Printf("%+v\n\n", verdict)
+			case job := <-w.testJobChannel:
+				w.runOuterJob(job)
+			case <-w.queue.ready:
+				item := w.queue.pop()
+				if item == nil {
+					continue
+				} else if item.submission != nil {
+					w.setState(WorkerSubmission, item.submission.ID, item.submission.Task.Name)
+					w.runSubmission(*item.submission)
+				} else if item.stress != nil {
+					w.setState(WorkerStressTest, item.stress.ID, item.stress.TaskName)
+					w.runStressTest(*item.stress)
+				} else {
+					w.setState(WorkerCustomTest, item.test.ID, item.test.TaskName)
+					w.runCustomTest(*item.test)
 				}
-				w.testVerdictChannel <- verdict
+				w.setState(WorkerIdle, 0, "")
 			}
 		}
 	}()
 }
 
+// runOuterJob runs a test of a submission judged by another worker, while
+// this one isn't judging anything.
+func (w *judgeWorker) runOuterJob(job testJob) {
+	s := job.judging.submission
+	w.setState(WorkerJob, s.ID, s.Task.Name)
+	w.runJob(job)
+	w.setState(WorkerIdle, 0, "")
+}
+
+func (w *judgeWorker) runSubmission(s Submission) {
+	var verdict TaskVerdict
+	if !s.cancelled() {
+		verdict = w.judge(s)
+	}
+	if s.cancelled() {
+		verdict = TaskVerdict{Cancelled: true}
+	}
+	if !w.parent.finish(s) {
+		return
+	}
+
+	verdict = withSubmission(verdict, s)
+
+	if testingFlag {
+		fmt.Printf("%+v\n\n", verdict)
+	}
+	w.taskVerdictChannel <- verdict
+}
+
+// withSubmission fills in the information about the submission a verdict
+// belongs to.
+func withSubmission(verdict TaskVerdict, s Submission) TaskVerdict {
+	verdict.ID = s.ID
+	verdict.SID = s.SID
+	verdict.When = s.When
+	verdict.Elapsed = s.Elapsed
+	verdict.TaskName = s.Task.Name
+	verdict.Code = string(s.Code)
+	if s.Lang != nil {
+		verdict.LangMime = s.Lang.MimeType()
+		verdict.LangName = s.Lang.Name()
+	}
+	return verdict
+}
+
+func (w *judgeWorker) runCustomTest(t CustomTest) {
+	verdict := w.test(t)
+
+	verdict.ID = t.ID
+	verdict.SID = t.SID
+	verdict.When = t.When
+	verdict.TaskName = "_test"
+	verdict.Code = string(t.Code)
+	verdict.LangMime = t.Lang.MimeType()
+	verdict.LangName = t.Lang.Name()
+
+	if testingFlag {
+		fmt.Printf("%+v\n\n", verdict)
+	}
+	w.testVerdictChannel <- verdict
+}
+
 func (w *judgeWorker) stop() {
 	w.stopChannel <- true
 }
 
-func (w *judgeWorker) prepare(lang Language, source []byte, sourceFilename string) (*Box, error) {
-	box, err := Sandbox(w.id)
+// sandbox initializes the n-th sandbox instance owned by this worker.
+func (w *judgeWorker) sandbox(n int) (*Box, error) {
+	return Sandbox(w.id*boxesPerWorker + n)
+}
+
+// prepare initializes the n-th sandbox instance owned by this worker and
+// copies the specified files into it.
+func (w *judgeWorker) prepare(n int, lang Language, files []FileData) (*Box, error) {
+	box, err := w.sandbox(n)
 	if err != nil {
 		return nil, err
 	}
 
 	err = lang.CopyExtraFiles(box.BoxPath)
 	if err != nil {
+		box.Clear()
 		return nil, err
 	}
 
-	err = ioutil.WriteFile(filepath.Join(box.BoxPath, "box", sourceFilename), source, 0666)
-	if err != nil {
-		return nil, err
+	for _, file := range files {
+		path := filepath.Join(box.BoxPath, "box", file.Name)
+
+		mode := file.Mode
+		if mode == 0 {
+			mode = 0666
+		}
+
+		err = os.MkdirAll(filepath.Dir(path), 0777)
+		if err != nil {
+			box.Clear()
+			return nil, err
+		}
+
+		err = ioutil.WriteFile(path, file.Content, mode)
+		if err != nil {
+			box.Clear()
+			return nil, err
+		}
 	}
 
 	return box, nil
 }
 
-func (w *judgeWorker) compile(box *Box, compilationCommand []string) (bool, int, string) {
+// withGraders returns the files to be copied into the sandbox, the sources to
+// be compiled and the name of the executable for a submission to the specified
+// task. Grader sources written in the submission language are compiled
+// together with it, and the one chosen by graderEntry becomes the entry point
+// of the program. Any other grader files, such as headers, are only copied.
+func withGraders(taskName string, lang Language, code []byte, graders []FileData) ([]FileData, []string, string) {
+	ext := lang.SourceExtension()
+	submission := FileData{Name: taskName + ext, Content: code}
+
+	var files []FileData
+	var names []string
+	for _, file := range graders {
+		if file.Name == submission.Name {
+			continue
+		}
+
+		files = append(files, file)
+		names = append(names, file.Name)
+	}
+
+	files = append(files, submission)
+
+	entry := graderEntry(taskName, ext, names)
+	if len(entry) == 0 {
+		return files, []string{submission.Name}, taskName
+	}
+
+	sources := []string{entry}
+	for _, name := range names {
+		if filepath.Ext(name) == ext && name != entry {
+			sources = append(sources, name)
+		}
+	}
+
+	return files, append(sources, submission.Name), strings.TrimSuffix(entry, ext)
+}
+
+// graderEntry returns the grader source, among those with the specified
+// extension, that is the entry point of the program: the only one or, when
+// there are several, the one named grader (Grader in Java). It's empty when
+// there are no such sources or none of them can be chosen.
+func graderEntry(taskName, ext string, names []string) string {
+	var sources []string
+	for _, name := range names {
+		if filepath.Ext(name) == ext && name != taskName+ext {
+			sources = append(sources, name)
+		}
+	}
+
+	if len(sources) == 1 {
+		return sources[0]
+	}
+
+	entry := ""
+	for _, name := range sources {
+		if strings.EqualFold(strings.TrimSuffix(name, ext), "grader") {
+			if len(entry) > 0 {
+				return ""
+			}
+			entry = name
+		}
+	}
+
+	return entry
+}
+
+// checkGraders returns an error if the grader files of a task have several
+// sources in the same language without one of them being the entry point.
+func checkGraders(taskName string, names []string) error {
+	for _, name := range names {
+		ext := filepath.Ext(name)
+		if LanguageByExtension(ext) == nil || name == taskName+ext {
+			continue
+		}
+
+		if len(graderEntry(taskName, ext, names)) == 0 {
+			return errors.New("Task " + taskName + " has several " + ext + " graders and none of them is named grader")
+		}
+	}
+
+	return nil
+}
+
+func (w *judgeWorker) compile(box *Box, compilationCommand []string, cancel <-chan struct{}) (bool, int, string) {
 	if compilationCommand == nil {
 		return true, ResultCompSuccess, ""
 	}
@@ -291,6 +908,7 @@ func (w *judgeWorker) compile(box *Box, compilationCommand []string) (bool, int,
 		MemoryLimit:   25 << 19, // 2.5GB
 		CPUTimeLimit:  2 * time.Minute,
 		WallTimeLimit: 2 * time.Minute,
+		Cancel:        cancel,
 	})
 
 	outputFile.Close()
@@ -310,6 +928,8 @@ func (w *judgeWorker) compile(box *Box, compilationCommand []string) (bool, int,
 		return true, ResultCompTimeout, ""
 	} else if result.Status == StatusSig {
 		return true, ResultCompSignal, result.Signal.String()
+	} else if result.Status == StatusMLE {
+		return true, ResultCompSignal, "Memory limit exceeded"
 	} else if result.Status == StatusExit {
 		return true, ResultCompFailed, "Exit Code: " + strconv.Itoa(result.ExitCode) + "\n" + string(output)
 	} else if result.Status == StatusOK {
@@ -320,19 +940,40 @@ func (w *judgeWorker) compile(box *Box, compilationCommand []string) (bool, int,
 }
 
 func (w *judgeWorker) judge(s Submission) TaskVerdict {
-	box, err := w.prepare(s.Lang, s.Code, s.Task.Name+s.Lang.SourceExtension())
-	if err != nil {
-		return TaskVerdict{Error: true, Extra: err.Error()}
-	}
-	defer box.Clear()
+	w.unload()
+	defer w.unload()
 
-	compilationCommand := s.Lang.CompilationCommand([]string{s.Task.Name + s.Lang.SourceExtension()}, s.Task.Name)
+	j := &judging{submission: s}
 
-	ok, compilationResult, compilationExtra := w.compile(box, compilationCommand)
-	if !ok {
-		return TaskVerdict{Error: true, Extra: compilationExtra}
-	} else if compilationResult != ResultCompSuccess {
-		return TaskVerdict{Compilation: compilationResult, Extra: compilationExtra}
+	// output-only submissions have nothing to compile or execute
+	if s.Task.Type != TaskTypeOutputOnly {
+		graders, err := s.DB.Graders(s.Task.Name, s.Key)
+		if err != nil {
+			return TaskVerdict{Error: true, Extra: err.Error()}
+		}
+
+		files, sources, executable := withGraders(s.Task.Name, s.Lang, s.Code, graders)
+
+		w.box, err = w.prepare(0, s.Lang, files)
+		if err != nil {
+			return TaskVerdict{Error: true, Extra: err.Error()}
+		}
+
+		compilationCommand := s.Lang.CompilationCommand(sources, executable)
+
+		w.parent.publish(s, ProgressCompiling, 0, 0)
+		ok, compilationResult, compilationExtra := w.compile(w.box, compilationCommand, s.cancel)
+		if !ok {
+			return TaskVerdict{Error: true, Extra: compilationExtra}
+		} else if compilationResult != ResultCompSuccess {
+			return TaskVerdict{Compilation: compilationResult, Extra: compilationExtra}
+		}
+
+		j.executable = executable
+		j.program, err = boxFiles(w.box)
+		if err != nil {
+			return TaskVerdict{Error: true, Extra: err.Error()}
+		}
 	}
 
 	var ret TaskVerdict
@@ -343,128 +984,385 @@ func (w *judgeWorker) judge(s Submission) TaskVerdict {
 		return TaskVerdict{Error: true, Extra: err.Error()}
 	}
 
-	if len(s.Task.Batches) == 0 {
+	switch s.Task.Type {
+	case TaskTypeInteractive:
+		files, err := s.DB.Interactor(s.Task.Name, s.Key)
+		if err != nil {
+			return TaskVerdict{Error: true, Extra: err.Error()}
+		}
+
+		w.helper, err = w.prepareTaskProgram(1, files, s.Task.Interactor)
+		if err != nil {
+			return TaskVerdict{Error: true, Extra: "Interactor: " + err.Error()}
+		}
+	case "", TaskTypeBatch, TaskTypeOutputOnly:
+		j.compare, err = NewComparator(s.Task.Comparator)
+		if err != nil {
+			return TaskVerdict{Error: true, Extra: err.Error()}
+		}
+
+		if len(s.Task.Checker) > 0 {
+			files, err := s.DB.Checker(s.Task.Name, s.Key)
+			if err != nil {
+				return TaskVerdict{Error: true, Extra: err.Error()}
+			}
+
+			w.helper, err = w.prepareTaskProgram(1, files, s.Task.Checker)
+			if err != nil {
+				return TaskVerdict{Error: true, Extra: "Checker: " + err.Error()}
+			}
+		}
+	default:
+		return TaskVerdict{Error: true, Extra: "Unknown task type " + s.Task.Type}
+	}
+
+	if w.helper != nil {
+		j.helperLang = w.helper.lang
+		j.helperExecutable = w.helper.executable
+		j.helperFiles, err = boxFiles(w.helper.box)
+		if err != nil {
+			return TaskVerdict{Error: true, Extra: err.Error()}
+		}
+	}
+
+	// the task may be shared with other submissions, so it's left untouched
+	taskBatches := s.Task.Batches
+	if len(taskBatches) == 0 {
 		tests := make([]int, s.Task.NTests)
 		for i := 0; i < s.Task.NTests; i++ {
 			tests[i] = i
 		}
-		s.Task.Batches = []BatchData{{100, tests}}
+		taskBatches = []BatchData{{Value: defaultBatchValue, Tests: tests}}
 	}
 
-	results := make([]struct {
-		code   int
-		extra  string
-		time   time.Duration
-		memory int64
-	}, len(tests))
+	batches := make([]BatchData, len(taskBatches))
+	for batchNumber, batch := range taskBatches {
+		if len(batch.Aggregation) == 0 {
+			batch.Aggregation = s.Task.Aggregation
+		}
+
+		switch batch.Aggregation {
+		case "", AggregationMin, AggregationSum, AggregationProduct:
+		default:
+			return TaskVerdict{Error: true, Extra: "Unknown aggregation " + batch.Aggregation}
+		}
 
-	ret.Batches = make([]BatchVerdict, len(s.Task.Batches))
+		batches[batchNumber] = batch
+	}
+
+	j.batches = batches
+	j.failed = make([]bool, len(batches))
+	w.loaded = j
 
-	for batchNumber, batch := range s.Task.Batches {
+	results, err := w.dispatch(j, tests)
+	if err != nil {
+		return TaskVerdict{Error: true, Extra: err.Error()}
+	}
+
+	ret.Batches = make([]BatchVerdict, len(batches))
+
+	for batchNumber, batch := range batches {
 		ret.Batches[batchNumber].Result = ResultCorrect
+		lowestScore := 1.0
+		scores := make([]float64, 0, len(batch.Tests))
 
 		for _, i := range batch.Tests {
-			test := tests[i]
-			if results[i].code == ResultNothing {
-				command := s.Lang.EvaluationCommand(s.Task.Name, nil, s.Task.MemoryLimit)
+			// tests skipped because the batch had already failed
+			if results[i].Result == ResultNothing {
+				continue
+			}
 
-				outputFile, err := os.Create(filepath.Join(box.BoxPath, "box", ".output"))
-				if err != nil {
-					return TaskVerdict{Error: true, Extra: err.Error()}
-				}
+			if results[i].Time > ret.Batches[batchNumber].Time {
+				ret.Batches[batchNumber].Time = results[i].Time
+			}
+
+			if results[i].Memory > ret.Batches[batchNumber].Memory {
+				ret.Batches[batchNumber].Memory = results[i].Memory
+			}
 
-				boxConfig := &BoxConfig{
-					Path:          command[0],
-					Args:          command,
-					Env:           env,
-					Stdin:         bytes.NewReader(test.Input),
-					Stdout:        outputFile,
-					Stderr:        outputFile,
-					EnableCgroups: true,
-					CPUTimeLimit:  time.Duration(s.Task.TimeLimit) * time.Millisecond,
-					WallTimeLimit: time.Duration(s.Task.TimeLimit) * time.Millisecond,
+			scores = append(scores, results[i].Score)
+			ret.Batches[batchNumber].Tests = append(ret.Batches[batchNumber].Tests, i)
+
+			// the batch result is its first failure or, if there are none,
+			// its lowest partial score
+			result := ret.Batches[batchNumber].Result
+			if results[i].Result == ResultPartial {
+				if result == ResultCorrect || (result == ResultPartial && results[i].Score < lowestScore) {
+					lowestScore = results[i].Score
+					ret.Batches[batchNumber].Result = results[i].Result
+					ret.Batches[batchNumber].Extra = results[i].Extra
+				}
+			} else if results[i].Result != ResultCorrect {
+				if result == ResultCorrect || result == ResultPartial {
+					ret.Batches[batchNumber].Result = results[i].Result
+					ret.Batches[batchNumber].Extra = results[i].Extra
 				}
 
-				if s.Lang.UseMemoryLimit() {
-					boxConfig.MemoryLimit = int64(s.Task.MemoryLimit)
+				// a failed test zeroes the whole batch, unless scores are summed,
+				// so the remaining ones are only reported on full evaluations
+				if batch.Aggregation != AggregationSum && !s.FullEvaluation {
+					break
 				}
+			}
+		}
 
-				result := box.Run(boxConfig)
+		ret.Batches[batchNumber].Score = float64(batch.Value) * aggregate(batch.Aggregation, scores, len(batch.Tests))
+	}
 
-				outputFile.Close()
-				output, err := ioutil.ReadFile(filepath.Join(box.BoxPath, "box", ".output"))
+	for _, result := range results {
+		if result.Result != ResultNothing {
+			ret.Tests = append(ret.Tests, result)
+		}
+	}
 
-				if testingFlag {
-					fmt.Printf("Test %d output: %s\n", i, string(output))
-				}
+	return ret
+}
 
-				if err != nil {
-					return TaskVerdict{Error: true, Extra: err.Error()}
-				}
+// aggregate combines the fractions of the score obtained in the tests of a
+// batch with n tests into the fraction of the batch score obtained, according
+// to the specified aggregation rule.
+func aggregate(aggregation string, scores []float64, n int) float64 {
+	switch aggregation {
+	case AggregationSum:
+		if n == 0 {
+			return 1
+		}
 
-				if testingFlag {
-					fmt.Printf("Test %d: %+v\n", i, result)
-				}
+		var sum float64
+		for _, score := range scores {
+			sum += score
+		}
+		return sum / float64(n)
+	case AggregationProduct:
+		product := 1.0
+		for _, score := range scores {
+			product *= score
+		}
+		return product
+	default:
+		min := 1.0
+		for _, score := range scores {
+			if score < min {
+				min = score
+			}
+		}
+		return min
+	}
+}
 
-				if result.Status == StatusError {
-					return TaskVerdict{Error: true, Extra: result.Error}
-				}
+// setStatus fills the result code and extra information from the status of
+// the submission execution.
+func (r *TestVerdict) setStatus(result *BoxResult) {
+	r.Time = result.CPUTime
+	r.WallTime = result.WallTime
+	r.Memory = result.Memory
+	r.ExitCode = result.ExitCode
+
+	if result.Status == StatusCTL {
+		r.Result = ResultTimeout
+	} else if result.Status == StatusWTL {
+		r.Result = ResultWallTimeout
+	} else if result.Status == StatusOLE {
+		r.Result = ResultOutputLimit
+	} else if result.Status == StatusMLE {
+		r.Result = ResultMemoryLimit
+	} else if result.Status == StatusSig {
+		r.Result = ResultSignal
+		r.Signal = result.Signal.String()
+		r.Extra = result.Signal.String()
+	} else if result.Status == StatusExit {
+		r.Result = ResultFailed
+		r.Extra = "Exit Code: " + strconv.Itoa(result.ExitCode)
+	} else if result.Status == StatusOK {
+		r.Result = ResultCorrect
+	}
+}
 
-				results[i].time = result.CPUTime
-				results[i].memory = result.Memory
-
-				if result.Status == StatusWTL || result.Status == StatusCTL {
-					results[i].code = ResultTimeout
-				} else if result.Status == StatusSig {
-					results[i].code = ResultSignal
-					results[i].extra = result.Signal.String()
-				} else if result.Status == StatusExit {
-					results[i].code = ResultFailed
-					results[i].extra = "Exit Code: " + strconv.Itoa(result.ExitCode)
-				} else if result.Status == StatusOK {
-					results[i].code = ResultCorrect
-				}
+// submissionConfig returns the BoxConfig used to run the compiled submission
+// under the task limits. The wall time limit has some slack over the CPU time
+// one, as the wall time of a program is never below its CPU time.
+func submissionConfig(s Submission, executable string) *BoxConfig {
+	command := s.Lang.EvaluationCommand(executable, nil, s.Task.MemoryLimit)
+	timeLimit := time.Duration(s.Task.TimeLimit) * time.Millisecond
 
-				if results[i].code == ResultCorrect {
-					if strings.Compare(strip(string(output)), strip(string(test.Output))) != 0 {
-						results[i].code = ResultWrong
-					}
-				}
-			}
+	boxConfig := &BoxConfig{
+		Path:          command[0],
+		Args:          command,
+		Env:           env,
+		EnableCgroups: true,
+		CPUTimeLimit:  timeLimit,
+		WallTimeLimit: 2*timeLimit + time.Second,
+		OutputLimit:   defaultOutputLimit,
+		Cancel:        s.cancel,
+	}
 
-			if results[i].time > ret.Batches[batchNumber].Time {
-				ret.Batches[batchNumber].Time = results[i].time
-			}
+	if s.Task.OutputLimit != 0 {
+		boxConfig.OutputLimit = int64(s.Task.OutputLimit)
+	}
 
-			if results[i].memory > ret.Batches[batchNumber].Memory {
-				ret.Batches[batchNumber].Memory = results[i].memory
-			}
+	if s.Lang.UseMemoryLimit() {
+		boxConfig.MemoryLimit = int64(s.Task.MemoryLimit)
+	}
 
-			if results[i].code != ResultCorrect {
-				ret.Batches[batchNumber].Result = results[i].code
-				ret.Batches[batchNumber].Extra = results[i].extra
-				break
-			}
+	return boxConfig
+}
+
+// evaluate runs the submission over a single test of a batch task, checking
+// its output afterwards. The checking function, if any, is called right
+// before the output is checked.
+func (w *judgeWorker) evaluate(box *Box, s Submission, executable string, test TestData, compare Comparator, checker *taskProgram, checking func()) (TestVerdict, error) {
+	var ret TestVerdict
+
+	outputFile, err := os.Create(filepath.Join(box.BoxPath, "box", ".output"))
+	if err != nil {
+		return ret, err
+	}
+
+	boxConfig := submissionConfig(s, executable)
+	boxConfig.Stdout = outputFile
+	boxConfig.Stderr = outputFile
+
+	err = setupFileIO(box, s.Task, test.Input, boxConfig)
+	if err != nil {
+		outputFile.Close()
+		return ret, err
+	}
+
+	result := box.Run(boxConfig)
+
+	outputFile.Close()
+	output, missing, err := readOutput(box, s.Task)
+	if err != nil {
+		return ret, err
+	}
+
+	if testingFlag {
+		fmt.Printf("Output: %s\n", string(output))
+	}
+
+	if result.Status == StatusError {
+		return ret, errors.New(result.Error)
+	}
+
+	ret.setStatus(result)
+
+	if ret.Result == ResultCorrect && missing {
+		ret.Result = ResultWrong
+		ret.Extra = "Output file " + s.Task.OutputFile + " was not created"
+	}
+
+	if ret.Result == ResultCorrect {
+		if checking != nil {
+			checking()
 		}
 
-		if ret.Batches[batchNumber].Result == ResultCorrect {
-			ret.Batches[batchNumber].Score = batch.Value
+		checked, err := checkOutput(output, test, compare, checker)
+		if err != nil {
+			return ret, err
 		}
+		ret.Result, ret.Score, ret.Extra = checked.Result, checked.Score, checked.Extra
 	}
 
-	return ret
+	return ret, nil
+}
+
+// setupFileIO places the test input where the submission reads it from,
+// which is either its standard input or the task input file, and removes the
+// task output file left by previous executions, if any.
+func setupFileIO(box *Box, task *TaskData, input []byte, config *BoxConfig) error {
+	for _, name := range []string{task.InputFile, task.OutputFile} {
+		if len(name) > 0 && (filepath.Base(name) != name || strings.HasPrefix(name, ".")) {
+			return errors.New("Invalid file name " + name)
+		}
+	}
+
+	if len(task.InputFile) > 0 {
+		err := ioutil.WriteFile(filepath.Join(box.BoxPath, "box", task.InputFile), input, 0666)
+		if err != nil {
+			return err
+		}
+	} else {
+		config.Stdin = bytes.NewReader(input)
+	}
+
+	if len(task.OutputFile) > 0 {
+		err := os.Remove(filepath.Join(box.BoxPath, "box", task.OutputFile))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readOutput reads what the submission wrote as its output, which is either
+// its standard output or the task output file, also reporting whether the
+// latter is missing.
+func readOutput(box *Box, task *TaskData) ([]byte, bool, error) {
+	name := ".output"
+	if len(task.OutputFile) > 0 {
+		name = task.OutputFile
+	}
+
+	output, err := ioutil.ReadFile(filepath.Join(box.BoxPath, "box", name))
+	if os.IsNotExist(err) && len(task.OutputFile) > 0 {
+		return nil, true, nil
+	}
+
+	return output, false, err
+}
+
+// checkOutput compares the output of a submission over a single test with the
+// expected one, through the checker, if there is one, or through the task
+// comparator otherwise.
+func checkOutput(output []byte, test TestData, compare Comparator, checker *taskProgram) (TestVerdict, error) {
+	var ret TestVerdict
+
+	if checker != nil {
+		var err error
+		ret.Result, ret.Score, ret.Extra, err = checker.check(test, output)
+		if err != nil {
+			return ret, errors.New("Checker: " + err.Error())
+		}
+	} else if !compare(output, test.Output) {
+		ret.Result = ResultWrong
+	} else {
+		ret.Result = ResultCorrect
+		ret.Score = 1
+	}
+
+	return ret, nil
 }
 
 func (w *judgeWorker) test(t CustomTest) CustomTestVerdict {
-	box, err := w.prepare(t.Lang, t.Code, t.TaskName+t.Lang.SourceExtension())
+	w.unload()
+
+	var task TaskData
+	var graders []FileData
+	if t.DB != nil {
+		var err error
+		task, err = t.DB.Task(t.TaskName)
+		if err != nil {
+			return CustomTestVerdict{Error: true, Extra: err.Error()}
+		}
+
+		graders, err = t.DB.Graders(t.TaskName, t.Key)
+		if err != nil {
+			return CustomTestVerdict{Error: true, Extra: err.Error()}
+		}
+	}
+
+	files, sources, executable := withGraders(t.TaskName, t.Lang, t.Code, graders)
+
+	box, err := w.prepare(0, t.Lang, files)
 	if err != nil {
 		return CustomTestVerdict{Error: true, Extra: err.Error()}
 	}
 	defer box.Clear()
 
-	compilationCommand := t.Lang.CompilationCommand([]string{t.TaskName + t.Lang.SourceExtension()}, t.TaskName)
+	compilationCommand := t.Lang.CompilationCommand(sources, executable)
 
-	ok, compilationResult, compilationExtra := w.compile(box, compilationCommand)
+	ok, compilationResult, compilationExtra := w.compile(box, compilationCommand, nil)
 	if !ok {
 		return CustomTestVerdict{Error: true, Extra: compilationExtra}
 	} else if compilationResult != ResultCompSuccess {
@@ -474,7 +1372,7 @@ func (w *judgeWorker) test(t CustomTest) CustomTestVerdict {
 	var ret CustomTestVerdict
 	ret.Compilation = ResultCompSuccess
 
-	command := t.Lang.EvaluationCommand(t.TaskName, nil, 25<<19) // 2.5GB
+	command := t.Lang.EvaluationCommand(executable, nil, 25<<19) // 2.5GB
 
 	outputFile, err := os.Create(filepath.Join(box.BoxPath, "box", ".output"))
 	if err != nil {
@@ -485,21 +1383,27 @@ func (w *judgeWorker) test(t CustomTest) CustomTestVerdict {
 		Path:          command[0],
 		Args:          command,
 		Env:           env,
-		Stdin:         bytes.NewReader(t.Input),
 		Stdout:        outputFile,
 		EnableCgroups: true,
 		CPUTimeLimit:  2 * time.Minute,
 		WallTimeLimit: 2 * time.Minute,
+		OutputLimit:   defaultOutputLimit,
 	}
 
 	if t.Lang.UseMemoryLimit() {
 		boxConfig.MemoryLimit = 25 << 19 // 2.5GB
 	}
 
+	err = setupFileIO(box, &task, t.Input, boxConfig)
+	if err != nil {
+		outputFile.Close()
+		return CustomTestVerdict{Error: true, Extra: err.Error()}
+	}
+
 	result := box.Run(boxConfig)
 
 	outputFile.Close()
-	output, err := ioutil.ReadFile(filepath.Join(box.BoxPath, "box", ".output"))
+	output, missing, err := readOutput(box, &task)
 
 	if err != nil {
 		return CustomTestVerdict{Error: true, Extra: err.Error()}
@@ -518,8 +1422,14 @@ func (w *judgeWorker) test(t CustomTest) CustomTestVerdict {
 	ret.Time = result.CPUTime
 	ret.Memory = result.Memory
 
-	if result.Status == StatusWTL || result.Status == StatusCTL {
+	if result.Status == StatusCTL {
 		ret.Result = ResultTimeout
+	} else if result.Status == StatusWTL {
+		ret.Result = ResultWallTimeout
+	} else if result.Status == StatusOLE {
+		ret.Result = ResultOutputLimit
+	} else if result.Status == StatusMLE {
+		ret.Result = ResultMemoryLimit
 	} else if result.Status == StatusSig {
 		ret.Result = ResultSignal
 		ret.Extra = result.Signal.String()
@@ -530,5 +1440,9 @@ func (w *judgeWorker) test(t CustomTest) CustomTestVerdict {
 		ret.Result = ResultCorrect
 	}
 
+	if ret.Result == ResultCorrect && missing {
+		ret.Extra = "Output file " + task.OutputFile + " was not created"
+	}
+
 	return ret
 }
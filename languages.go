@@ -8,6 +8,30 @@ import (
 // AllLanguages is an array with all the programming languages support by the judge.
 var AllLanguages = []Language{&cpp{}, &c{}, &java{}, &pas{}, &py2{}, &py3{}, &js{}}
 
+// LanguageByExtension returns the first language from AllLanguages whose
+// source extension matches the specified one, or nil if there is none.
+func LanguageByExtension(extension string) Language {
+	for _, lang := range AllLanguages {
+		if lang.SourceExtension() == extension {
+			return lang
+		}
+	}
+
+	return nil
+}
+
+// LanguageByName returns the language with the specified name, or nil if
+// there is none.
+func LanguageByName(name string) Language {
+	for _, lang := range AllLanguages {
+		if lang.Name() == name {
+			return lang
+		}
+	}
+
+	return nil
+}
+
 // Language keeps information and methods related to a single programming
 // language, indicating how it should be judged.
 type Language interface {
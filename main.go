@@ -2,12 +2,16 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"time"
 
 	rice "github.com/GeertJohan/go.rice"
 	"github.com/nicksnyder/go-i18n/i18n"
@@ -18,7 +22,7 @@ var (
 	appVersion      = "testing"
 	appBuild        = "testing"
 	appInfo         = "Created by Gabriel Simões (simoes.sgabriel@gmail.com)"
-	appHelp         = "Usage: %s run OR builddb OR info\nAppend -h or --help to display general or subcommand usage\n"
+	appHelp         = "Usage: %s run OR builddb OR judge OR stress OR info\nAppend -h or --help to display general or subcommand usage\n"
 	appErrorMessage = "[OBIJUDGE] "
 
 	testingFlag bool
@@ -27,12 +31,18 @@ var (
 func main() {
 	runCommand := flag.NewFlagSet("run", flag.ExitOnError)
 	builddbCommand := flag.NewFlagSet("builddb", flag.ExitOnError)
+	judgeCommand := flag.NewFlagSet("judge", flag.ExitOnError)
+	stressCommand := flag.NewFlagSet("stress", flag.ExitOnError)
 
 	portPtr := runCommand.Int("port", 80, "Port where interface will listen (localhost-only")
 	referencePtr := runCommand.String("reference", "reference.zip", "File where language reference is stored")
 	workersPtr := runCommand.Int("workers", 2, "Number of simultaneous judge workers")
 	localePtr := runCommand.String("locale", "en-US", "Default localization to use in web interface")
 	contestsFolderPtr := runCommand.String("contestsfolder", "/obicontests", "Folder to store contests uploaded by users")
+	rosterPtr := runCommand.String("roster", "", "CSV file with the username,password[,name] of each contestant, enabling the multi-contestant mode")
+	contestPtr := runCommand.String("contest", "contest.zip", "Contest shared by all contestants in the multi-contestant mode")
+	contestPasswordPtr := runCommand.String("contestpassword", "", "Password of the contest shared in the multi-contestant mode")
+	adminPasswordPtr := runCommand.String("adminpassword", "", "Password of the admin area at /admin (disabled if empty)")
 	runCommand.BoolVar(&testingFlag, "testing", false, "Whether to use testing features or not (no authentication, reads password from ./pass file, uses judge_test as the contest, uses testing cookies session, prints debug messages)")
 
 	sourcePtr := builddbCommand.String("source", "contest", "Folder where contests data is located")
@@ -40,6 +50,33 @@ func main() {
 	passwordPtr := builddbCommand.String("password", "", "16 letters password to encrypt database (will generate one if empty)")
 	writePasswordPtr := builddbCommand.Bool("writepassword", false, "Write password to ./pass file.")
 
+	judgeContestPtr := judgeCommand.String("contest", "contest.zip", "Contest database with the task")
+	judgePasswordPtr := judgeCommand.String("password", "", "Password of the contest database")
+	judgeTaskPtr := judgeCommand.String("task", "", "Name of the task to judge the files against")
+	judgeLangPtr := judgeCommand.String("lang", "", "Name of the language of the files, e.g. \"C++11 (g++)\" (guessed from each file extension if empty)")
+	judgeWorkersPtr := judgeCommand.Int("workers", 2, "Number of simultaneous judge workers")
+	judgeFullPtr := judgeCommand.Bool("full", false, "Run every test, even those of batches which already failed")
+	judgeJSONPtr := judgeCommand.Bool("json", false, "Print the verdicts as JSON instead of a table")
+	judgeCommand.Usage = func() {
+		fmt.Fprintf(judgeCommand.Output(), "Usage: %s judge [flags] FILE|FOLDER...\nJudges each source file as a submission, exiting with 1 unless all of them are correct\n", os.Args[0])
+		judgeCommand.PrintDefaults()
+	}
+
+	stressContestPtr := stressCommand.String("contest", "contest.zip", "Contest database with the task")
+	stressPasswordPtr := stressCommand.String("password", "", "Password of the contest database")
+	stressTaskPtr := stressCommand.String("task", "", "Name of the task whose limits and checker or comparator are used")
+	stressGeneratorPtr := stressCommand.String("generator", "", "Source file of the generator, called with a seed and a size as arguments")
+	stressBrutePtr := stressCommand.String("brute", "", "Source file of the brute force solution")
+	stressGeneratorLangPtr := stressCommand.String("generatorlang", "", "Name of the language of the generator (guessed from its extension if empty)")
+	stressBruteLangPtr := stressCommand.String("brutelang", "", "Name of the language of the brute force solution (guessed from its extension if empty)")
+	stressLangPtr := stressCommand.String("lang", "", "Name of the language of the candidate solution (guessed from its extension if empty)")
+	stressRunsPtr := stressCommand.Int("runs", defaultStressRuns, "Number of generated inputs to try, at most "+strconv.Itoa(maxStressRuns))
+	stressJSONPtr := stressCommand.Bool("json", false, "Print the verdict as JSON")
+	stressCommand.Usage = func() {
+		fmt.Fprintf(stressCommand.Output(), "Usage: %s stress [flags] FILE\nCompares the candidate solution in FILE with a brute force over generated inputs, exiting with 1 if they differ\n", os.Args[0])
+		stressCommand.PrintDefaults()
+	}
+
 	if len(os.Args) < 2 {
 		fmt.Printf(appHelp, os.Args[0])
 		os.Exit(0)
@@ -50,6 +87,10 @@ func main() {
 		runCommand.Parse(os.Args[2:])
 	case "builddb":
 		builddbCommand.Parse(os.Args[2:])
+	case "judge":
+		judgeCommand.Parse(os.Args[2:])
+	case "stress":
+		stressCommand.Parse(os.Args[2:])
 	case "info":
 		fmt.Println(appName, "version", appVersion)
 		fmt.Println(appInfo)
@@ -73,8 +114,11 @@ func main() {
 				return errors.New("Must be run as root group")
 			}
 
-			// setup folders
-			os.RemoveAll(*contestsFolderPtr)
+			if err := CheckWorkers(*workersPtr); err != nil {
+				return err
+			}
+
+			// setup folders, keeping the sessions stored in previous runs
 			if err := os.MkdirAll(*contestsFolderPtr, 0777); err != nil {
 				return err
 			}
@@ -112,6 +156,21 @@ func main() {
 				Judge:         judge,
 				Logger:        logger,
 				DefaultLocale: *localePtr,
+				AdminPassword: *adminPasswordPtr,
+			}
+
+			if len(*rosterPtr) > 0 {
+				server.ContestPath = *contestPtr
+				server.Roster, err = LoadRoster(*rosterPtr)
+				if err != nil {
+					return err
+				}
+
+				server.Contest, err = CopyDatabase(*contestPtr, *contestsFolderPtr, []byte(*contestPasswordPtr))
+				if err != nil {
+					return err
+				}
+				server.ContestPassword = []byte(*contestPasswordPtr)
 			}
 			if err := server.Start(); err != nil {
 				return err
@@ -136,4 +195,161 @@ func main() {
 			logger.Fatal(err)
 		}
 	}
+
+	if judgeCommand.Parsed() {
+		accepted, err := func() (bool, error) {
+			if os.Geteuid() != 0 {
+				return false, errors.New("Must be run as root")
+			}
+
+			if len(*judgeTaskPtr) == 0 || judgeCommand.NArg() == 0 {
+				judgeCommand.Usage()
+				return false, errors.New("A task and at least one file are required")
+			}
+
+			if err := CheckWorkers(*judgeWorkersPtr); err != nil {
+				return false, err
+			}
+
+			var lang Language
+			if len(*judgeLangPtr) > 0 {
+				lang = LanguageByName(*judgeLangPtr)
+				if lang == nil {
+					return false, errors.New("Language " + *judgeLangPtr + " doesn't have a runner")
+				}
+			}
+
+			files, err := gradeFiles(judgeCommand.Args(), lang)
+			if err != nil {
+				return false, err
+			}
+
+			folder, err := ioutil.TempDir("", appName)
+			if err != nil {
+				return false, err
+			}
+			defer os.RemoveAll(folder)
+
+			db, err := CopyDatabase(*judgeContestPtr, folder, []byte(*judgePasswordPtr))
+			if err != nil {
+				return false, err
+			}
+			defer db.Clear()
+
+			task, err := db.Task(*judgeTaskPtr)
+			if err != nil {
+				return false, err
+			}
+
+			judge := &Judge{NumWorkers: *judgeWorkersPtr}
+			judge.Start()
+			defer judge.Stop()
+
+			results, err := Grade(judge, db, []byte(*judgePasswordPtr), task, lang, files, *judgeFullPtr)
+			if err != nil {
+				return false, err
+			}
+
+			if *judgeJSONPtr {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(results)
+			} else {
+				err = WriteGradeTable(os.Stdout, results)
+			}
+			if err != nil {
+				return false, err
+			}
+
+			for _, r := range results {
+				if !r.Accepted() {
+					return false, nil
+				}
+			}
+			return true, nil
+		}()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if !accepted {
+			os.Exit(1)
+		}
+	}
+
+	if stressCommand.Parsed() {
+		passed, err := func() (bool, error) {
+			if os.Geteuid() != 0 {
+				return false, errors.New("Must be run as root")
+			}
+
+			if len(*stressTaskPtr) == 0 || len(*stressGeneratorPtr) == 0 || len(*stressBrutePtr) == 0 || stressCommand.NArg() != 1 {
+				stressCommand.Usage()
+				return false, errors.New("A task, a generator, a brute force and a single candidate file are required")
+			}
+
+			generator, err := ReadStressProgram(*stressGeneratorPtr, *stressGeneratorLangPtr)
+			if err != nil {
+				return false, err
+			}
+
+			brute, err := ReadStressProgram(*stressBrutePtr, *stressBruteLangPtr)
+			if err != nil {
+				return false, err
+			}
+
+			candidate, err := ReadStressProgram(stressCommand.Arg(0), *stressLangPtr)
+			if err != nil {
+				return false, err
+			}
+
+			folder, err := ioutil.TempDir("", appName)
+			if err != nil {
+				return false, err
+			}
+			defer os.RemoveAll(folder)
+
+			db, err := CopyDatabase(*stressContestPtr, folder, []byte(*stressPasswordPtr))
+			if err != nil {
+				return false, err
+			}
+			defer db.Clear()
+
+			judge := &Judge{NumWorkers: 1}
+			judge.Start()
+			defer judge.Stop()
+
+			verdict, err := RunStressTest(judge, StressTest{
+				SID:       "stress",
+				When:      time.Now(),
+				TaskName:  *stressTaskPtr,
+				Generator: generator,
+				Brute:     brute,
+				Candidate: candidate,
+				Runs:      *stressRunsPtr,
+				DB:        db,
+				Key:       []byte(*stressPasswordPtr),
+			})
+			if err != nil {
+				return false, err
+			}
+
+			if *stressJSONPtr {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(verdict); err != nil {
+					return false, err
+				}
+			} else {
+				WriteStressReport(os.Stdout, verdict)
+			}
+
+			return !verdict.Error && len(verdict.Program) == 0, nil
+		}()
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if !passed {
+			os.Exit(1)
+		}
+	}
 }
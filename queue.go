@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Priorities of the items in the judge queue, from the most urgent one.
+const (
+	priorityFirstSubmission = iota
+	prioritySubmission
+	priorityCustomTest
+	priorityStressTest
+)
+
+const queueLimit = 100
+
+// ErrQueueFull is returned when the judge queue can't hold any more items.
+var ErrQueueFull = errors.New("The judge queue is full, try again later")
+
+// queueItem is a submission, a custom test or a stress test waiting to be
+// judged.
+type queueItem struct {
+	submission *Submission
+	test       *CustomTest
+	stress     *StressTest
+	priority   int
+}
+
+func (item *queueItem) sid() string {
+	if item.submission != nil {
+		return item.submission.SID
+	} else if item.stress != nil {
+		return item.stress.SID
+	}
+	return item.test.SID
+}
+
+// judgeQueue holds the items waiting for a judge worker. Items are served by
+// priority and, within the same priority, in a round-robin between sessions,
+// so a single session can't starve the others.
+type judgeQueue struct {
+	limit int
+	items []*queueItem
+	ready chan struct{}
+	lock  sync.Mutex
+}
+
+func newJudgeQueue(limit int) *judgeQueue {
+	return &judgeQueue{
+		limit: limit,
+		ready: make(chan struct{}, limit),
+	}
+}
+
+// push adds an item to the queue, failing if it is already full.
+func (q *judgeQueue) push(item *queueItem) error {
+	q.lock.Lock()
+	if len(q.items) >= q.limit {
+		q.lock.Unlock()
+		return ErrQueueFull
+	}
+	q.items = append(q.items, item)
+	q.lock.Unlock()
+
+	// There is at least one signal for each item in the queue, so when the
+	// channel is full nobody is left without one.
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// pop removes the next item to be judged from the queue, returning nil if
+// there is none. It should be called after receiving from the ready channel.
+func (q *judgeQueue) pop() *queueItem {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	order := q.order()
+	if len(order) == 0 {
+		return nil
+	}
+
+	for i, item := range q.items {
+		if item == order[0] {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+
+	return order[0]
+}
+
+// remove takes a submission out of the queue, reporting whether it was
+// queued. The signal left in the ready channel makes a worker pop nothing.
+func (q *judgeQueue) remove(id uint32) bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for i, item := range q.items {
+		if item.submission != nil && item.submission.ID == id {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// position returns the 1-based position of a submission in the queue, or 0
+// if it isn't queued.
+func (q *judgeQueue) position(id uint32) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for i, item := range q.order() {
+		if item.submission != nil && item.submission.ID == id {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// QueueEntry describes an item waiting in the judge queue, either a
+// submission, a custom test or a stress test.
+type QueueEntry struct {
+	Position   int
+	ID         uint32
+	SID        string
+	Task       string
+	CustomTest bool
+	StressTest bool
+	Priority   int
+}
+
+// list describes the queued items in the order they will be served.
+func (q *judgeQueue) list() []QueueEntry {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	order := q.order()
+	ret := make([]QueueEntry, len(order))
+	for i, item := range order {
+		ret[i] = QueueEntry{Position: i + 1, SID: item.sid(), Priority: item.priority}
+		if item.submission != nil {
+			ret[i].ID = item.submission.ID
+			ret[i].Task = item.submission.Task.Name
+		} else if item.stress != nil {
+			ret[i].ID = item.stress.ID
+			ret[i].Task = item.stress.TaskName
+			ret[i].StressTest = true
+		} else {
+			ret[i].ID = item.test.ID
+			ret[i].Task = item.test.TaskName
+			ret[i].CustomTest = true
+		}
+	}
+
+	return ret
+}
+
+// order returns the queued items sorted in the order they will be served: by
+// priority, then by how many items of the same session and priority are
+// ahead of them, then by arrival.
+func (q *judgeQueue) order() []*queueItem {
+	type key struct {
+		sid      string
+		priority int
+	}
+
+	ahead := make(map[key]int)
+	rank := make(map[*queueItem]int)
+	for _, item := range q.items {
+		k := key{item.sid(), item.priority}
+		rank[item] = ahead[k]
+		ahead[k]++
+	}
+
+	order := make([]*queueItem, len(q.items))
+	copy(order, q.items)
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i].priority != order[j].priority {
+			return order[i].priority < order[j].priority
+		}
+		return rank[order[i]] < rank[order[j]]
+	})
+
+	return order
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Contestant stores information related to a single contestant of the
+// roster.
+type Contestant struct {
+	Username string
+	Password string
+	Name     string
+}
+
+// Roster stores the contestants allowed to log in when running in the
+// multi-contestant mode.
+type Roster struct {
+	Contestants []Contestant
+}
+
+// LoadRoster reads a roster from a CSV file with one contestant per line, in
+// the form username,password[,name]. Usernames may only contain letters,
+// digits, dots, dashes and underscores.
+func LoadRoster(path string) (*Roster, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+
+	roster := &Roster{}
+	usernames := make(map[string]bool)
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(record) < 2 {
+			return nil, errors.New("Roster line " + strconv.Itoa(line) + " must have an username and a password")
+		}
+
+		contestant := Contestant{
+			Username: strings.TrimSpace(record[0]),
+			Password: strings.TrimSpace(record[1]),
+		}
+		if len(record) > 2 {
+			contestant.Name = strings.TrimSpace(record[2])
+		}
+		if len(contestant.Name) == 0 {
+			contestant.Name = contestant.Username
+		}
+
+		if !validUsername(contestant.Username) {
+			return nil, errors.New("Invalid username " + contestant.Username + " at roster line " + strconv.Itoa(line))
+		} else if usernames[contestant.Username] {
+			return nil, errors.New("Repeated username " + contestant.Username + " at roster line " + strconv.Itoa(line))
+		}
+		usernames[contestant.Username] = true
+
+		roster.Contestants = append(roster.Contestants, contestant)
+	}
+
+	if len(roster.Contestants) == 0 {
+		return nil, errors.New("The roster has no contestants")
+	}
+
+	return roster, nil
+}
+
+func validUsername(username string) bool {
+	if len(username) == 0 {
+		return false
+	}
+
+	for _, r := range username {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Authenticate returns the contestant with the specified username, as long as
+// the password matches.
+func (r *Roster) Authenticate(username, password string) (Contestant, bool) {
+	for _, c := range r.Contestants {
+		if c.Username == username {
+			ok := subtle.ConstantTimeCompare([]byte(c.Password), []byte(password)) == 1
+			return c, ok
+		}
+	}
+
+	return Contestant{}, false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+// Scoring rules, indicating how the submissions of a task are combined into
+// the task score
+const (
+	// ScoringBest takes the best score among the submissions.
+	ScoringBest = "best"
+
+	// ScoringSubtask sums the best score of each batch among the submissions,
+	// as in OBI and IOI since 2017.
+	ScoringSubtask = "subtask"
+)
+
+// TaskScore stores the score of a contestant in a single task.
+type TaskScore struct {
+	Name        string
+	Title       string
+	Score       float64
+	MaxScore    float64
+	Submissions int
+}
+
+// ContestScore stores the score of a contestant in every task of a contest.
+type ContestScore struct {
+	Scoring  string
+	Tasks    []TaskScore
+	Total    float64
+	MaxTotal float64
+
+	// LastImprovement is the contest time of the last submission which has
+	// increased the total, used to break ties.
+	LastImprovement time.Duration
+}
+
+// MaxScore returns the highest score a submission may get in the task.
+func (t TaskData) MaxScore() float64 {
+	if len(t.Batches) == 0 {
+		return defaultBatchValue
+	}
+
+	score := 0
+	for _, batch := range t.Batches {
+		score += batch.Value
+	}
+	return float64(score)
+}
+
+// Score combines the verdicts of a contestant into the score of each task,
+// following the scoring rule of the contest, and their total.
+func (c ContestData) Score(verdicts []TaskVerdict) ContestScore {
+	scoring := c.Scoring
+	if scoring != ScoringSubtask {
+		scoring = ScoringBest
+	}
+
+	sorted := make([]TaskVerdict, len(verdicts))
+	copy(sorted, verdicts)
+	sort.Sort(taskVerdictsByID(sorted))
+
+	// the verdicts are added in the order they were sent, to find out when
+	// the total has last improved
+	ret := ContestScore{Scoring: scoring, Tasks: make([]TaskScore, len(c.Tasks))}
+	indexes := make(map[string]int)
+	accumulators := make([]taskAccumulator, len(c.Tasks))
+	for i, task := range c.Tasks {
+		indexes[task.Name] = i
+		ret.Tasks[i] = TaskScore{Name: task.Name, Title: task.Title, MaxScore: task.MaxScore()}
+		ret.MaxTotal += ret.Tasks[i].MaxScore
+	}
+
+	for _, v := range sorted {
+		i, ok := indexes[v.TaskName]
+		if !ok {
+			continue
+		}
+
+		before := accumulators[i].score(scoring)
+		accumulators[i].add(v)
+		after := accumulators[i].score(scoring)
+
+		ret.Tasks[i].Submissions++
+		if after > before {
+			ret.Tasks[i].Score = after
+			ret.Total += after - before
+			ret.LastImprovement = v.Elapsed
+		}
+	}
+
+	return ret
+}
+
+// taskAccumulator keeps the best scores of the submissions of a task.
+type taskAccumulator struct {
+	best    float64
+	batches []float64
+}
+
+func (a *taskAccumulator) add(v TaskVerdict) {
+	if score := v.Score(); score > a.best {
+		a.best = score
+	}
+
+	for i, batch := range v.Batches {
+		if i >= len(a.batches) {
+			a.batches = append(a.batches, batch.Score)
+		} else if batch.Score > a.batches[i] {
+			a.batches[i] = batch.Score
+		}
+	}
+}
+
+func (a *taskAccumulator) score(scoring string) float64 {
+	if scoring != ScoringSubtask {
+		return a.best
+	}
+
+	score := 0.0
+	for _, s := range a.batches {
+		score += s
+	}
+	return score
+}
@@ -0,0 +1,41 @@
+package main
+
+import "sort"
+
+// ScoreboardEntry stores the score and the position of a contestant in the
+// scoreboard.
+type ScoreboardEntry struct {
+	ContestScore
+	Rank     int
+	Username string
+	Name     string
+}
+
+// Scoreboard ranks the contestants of the roster.
+type Scoreboard struct {
+	Title   string
+	Tasks   []TaskScore
+	Entries []ScoreboardEntry
+}
+
+// rank sorts the entries by total score, breaking ties by the time of the
+// last submission which increased it, and numbers them. Contestants tied in
+// both share the same rank.
+func (sb *Scoreboard) rank() {
+	sort.SliceStable(sb.Entries, func(i, j int) bool {
+		a, b := sb.Entries[i], sb.Entries[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.LastImprovement < b.LastImprovement
+	})
+
+	for i := range sb.Entries {
+		if i > 0 && sb.Entries[i].Total == sb.Entries[i-1].Total &&
+			sb.Entries[i].LastImprovement == sb.Entries[i-1].LastImprovement {
+			sb.Entries[i].Rank = sb.Entries[i-1].Rank
+		} else {
+			sb.Entries[i].Rank = i + 1
+		}
+	}
+}
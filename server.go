@@ -1,17 +1,25 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"html"
 	"html/template"
 	"io/ioutil"
 	"log"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	rice "github.com/GeertJohan/go.rice"
 	"github.com/gorilla/mux"
@@ -32,19 +40,39 @@ type Server struct {
 	Logger        *log.Logger
 	DefaultLocale string
 
-	templates      *template.Template
-	sessionManager *SessionManager
-	server         *http.Server
+	// Roster, when set, enables the multi-contestant mode, in which the
+	// contestants it lists log in with their usernames and share Contest,
+	// the database loaded by the contest admin from ContestPath and opened
+	// with ContestPassword.
+	Roster          *Roster
+	Contest         *Database
+	ContestPath     string
+	ContestPassword []byte
+
+	// AdminPassword protects the admin area, which is disabled when it's
+	// empty.
+	AdminPassword string
+
+	contestLock sync.Mutex
+
+	templates       *template.Template
+	sessionManager  *SessionManager
+	server          *http.Server
+	shutdownChannel chan struct{}
 }
 
 // Start initializes a server instance, listening at the specified port.
 func (srv *Server) Start() error {
 	// setup session storage
 	if testingFlag {
-		srv.sessionManager = NewSessionManager(srv.Judge.TaskVerdictChannel, srv.Judge.TestVerdictChannel, "obijudge-testing")
+		srv.sessionManager = NewSessionManager(srv.Judge.TaskVerdictChannel, srv.Judge.TestVerdictChannel, srv.Judge.StressVerdictChannel, srv.Judge.ProgressChannel, "obijudge-testing")
 	} else {
 		randBytes, _ := generateKey(10)
-		srv.sessionManager = NewSessionManager(srv.Judge.TaskVerdictChannel, srv.Judge.TestVerdictChannel, "obijudge-"+string(randBytes))
+		srv.sessionManager = NewSessionManager(srv.Judge.TaskVerdictChannel, srv.Judge.TestVerdictChannel, srv.Judge.StressVerdictChannel, srv.Judge.ProgressChannel, "obijudge-"+string(randBytes))
+	}
+
+	if err := srv.restoreSessions(); err != nil {
+		return err
 	}
 
 	srv.sessionManager.StartWatcher()
@@ -93,16 +121,35 @@ func (srv *Server) Start() error {
 
 	r.Handle("/overview", srv.authWrapper(srv.overviewHandler)).Methods("GET")
 	r.Handle("/task/{name}.pdf", srv.authWrapper(srv.pdfHandler)).Methods("GET")
+	r.Handle("/task/{name}.zip", srv.authWrapper(srv.inputsHandler)).Methods("GET")
+	r.Handle("/export", srv.authWrapper(srv.exportHandler)).Methods("GET")
 	r.Handle("/task/{name}", srv.authWrapper(srv.taskHandler)).Methods("GET")
 	r.Handle("/submit/{name}", srv.authWrapper(srv.submitHandler)).Methods("POST")
 	r.Handle("/test/{name}", srv.authWrapper(srv.testHandler)).Methods("POST")
+	r.Handle("/stress/{name}", srv.authWrapper(srv.stressHandler)).Methods("POST")
+	r.Handle("/submission/{id:[0-9]+}/cancel", srv.authWrapper(srv.cancelHandler)).Methods("POST")
+	r.Handle("/submission/{id:[0-9]+}/rejudge", srv.authWrapper(srv.rejudgeHandler)).Methods("POST")
 
 	r.Handle("/getsubmission", srv.authWrapper(srv.getSubmissionHandler)).Methods("GET")
 	r.Handle("/gettest", srv.authWrapper(srv.getTestHandler)).Methods("GET")
+	r.Handle("/getstress", srv.authWrapper(srv.getStressHandler)).Methods("GET")
+	r.Handle("/getprogress", srv.authWrapper(srv.getProgressHandler)).Methods("GET")
+	r.Handle("/events", srv.authWrapper(srv.eventsHandler)).Methods("GET")
+	r.Handle("/gettimer", srv.authWrapper(srv.getTimerHandler)).Methods("GET")
+	r.Handle("/getscore", srv.authWrapper(srv.getScoreHandler)).Methods("GET")
+	r.Handle("/scoreboard", srv.authWrapper(srv.scoreboardHandler)).Methods("GET")
+	r.Handle("/getscoreboard", srv.authWrapper(srv.getScoreboardHandler)).Methods("GET")
 	r.Handle("/gettasks", srv.authWrapper(srv.getTasksHandler)).Methods("GET")
 	r.Handle("/gettasktitle", srv.authWrapper(srv.getTaskTitleHandler)).Methods("GET")
 	r.Handle("/getcode", srv.authWrapper(srv.getCode)).Methods("GET")
 	r.Handle("/setcode", srv.authWrapper(srv.setCode)).Methods("POST")
+	r.Handle("/getcodehistory", srv.authWrapper(srv.getCodeHistory)).Methods("GET")
+	r.Handle("/getcodediff", srv.authWrapper(srv.getCodeDiff)).Methods("GET")
+	r.Handle("/restorecode", srv.authWrapper(srv.restoreCode)).Methods("POST")
+	r.Handle("/clarification", srv.authWrapper(srv.clarificationHandler)).Methods("POST")
+	r.Handle("/getclarifications", srv.authWrapper(srv.getClarificationsHandler)).Methods("GET")
+
+	srv.setupAdminRoutes(r)
 
 	// setup http.Server
 	srv.server = &http.Server{
@@ -110,6 +157,12 @@ func (srv *Server) Start() error {
 		Handler: srv.loggingWrapper(srv.localeWrapper(r)),
 	}
 
+	// event streams never become idle, so they must end by themselves
+	srv.shutdownChannel = make(chan struct{})
+	srv.server.RegisterOnShutdown(func() {
+		close(srv.shutdownChannel)
+	})
+
 	// run server
 	go func() {
 		if err := srv.server.ListenAndServe(); err != nil {
@@ -120,6 +173,112 @@ func (srv *Server) Start() error {
 	return nil
 }
 
+// restoreSessions restores the sessions stored inside the database folder,
+// registering their submissions with the judge and removing the databases
+// no session uses anymore.
+func (srv *Server) restoreSessions() error {
+	err := srv.sessionManager.Persist(filepath.Join(srv.DatabasePath, "sessions"))
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]bool)
+
+	for _, s := range srv.sessionManager.Sessions() {
+		// the database shared by the contestants of the roster is copied
+		// again on every start, so the previous copy is dropped
+		if srv.Roster != nil && len(s.GetUser()) > 0 {
+			if old := s.GetDatabase(); old != nil {
+				old.Clear()
+			}
+			s.SetPassword(srv.ContestPassword)
+			s.SetDatabase(srv.Contest)
+		}
+
+		db := s.GetDatabase()
+		if db == nil {
+			continue
+		}
+		used[filepath.Base(db.path)] = true
+
+		for _, v := range s.GetSubmissions() {
+			task, err := db.Task(v.TaskName)
+			if err != nil {
+				task = TaskData{Name: v.TaskName}
+			}
+
+			srv.Judge.Restore(Submission{
+				ID:      v.ID,
+				SID:     v.SID,
+				When:    v.When,
+				Task:    &task,
+				Code:    []byte(v.Code),
+				Lang:    LanguageByName(v.LangName),
+				Elapsed: v.Elapsed,
+				DB:      db,
+				Key:     s.GetPassword(),
+			})
+		}
+
+		for _, v := range s.GetTests() {
+			srv.Judge.RestoreCustomTest(CustomTest{ID: v.ID})
+		}
+
+		// submissions interrupted by the restart are judged again from the
+		// start, or fail if that's no longer possible
+		for _, p := range s.GetPending() {
+			task, err := db.Task(p.TaskName)
+			if err != nil {
+				s.FailPending(p, "The judge restarted while judging this submission: "+err.Error())
+				continue
+			}
+
+			sub := Submission{
+				ID:             p.ID,
+				SID:            s.GetID(),
+				When:           p.When,
+				Task:           &task,
+				Code:           p.Code,
+				Lang:           LanguageByName(p.LangName),
+				Elapsed:        p.Elapsed,
+				DB:             db,
+				Key:            s.GetPassword(),
+				Outputs:        p.Outputs,
+				FullEvaluation: p.FullEvaluation,
+			}
+
+			if sub.Lang == nil && sub.Outputs == nil {
+				s.FailPending(p, "The judge restarted while judging this submission, and its language is no longer available")
+				continue
+			}
+
+			if err := srv.Judge.Requeue(sub); err != nil {
+				srv.Judge.Restore(sub)
+				s.FailPending(p, "The judge restarted while judging this submission, send it again or ask for it to be judged again: "+err.Error())
+			}
+		}
+	}
+
+	// the database of the roster was just copied into the same folder, and
+	// may not belong to any session yet
+	if contest, _ := srv.sharedContest(); contest != nil {
+		used[filepath.Base(contest.path)] = true
+	}
+
+	files, err := ioutil.ReadDir(srv.DatabasePath)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.Mode().IsRegular() && !used[file.Name()] {
+			os.Remove(filepath.Join(srv.DatabasePath, file.Name()))
+		}
+	}
+
+	return nil
+}
+
 // Stop is used to end of execution of a server instance. Typically, it should
 // be called at the end of program execution.
 func (srv *Server) Stop() {
@@ -128,6 +287,7 @@ func (srv *Server) Stop() {
 	}
 
 	srv.sessionManager.StopWatcher()
+	srv.sessionManager.Close()
 }
 
 func (srv *Server) getLang(r *http.Request) (i18n.TranslateFunc, error) {
@@ -156,6 +316,7 @@ func (srv *Server) render(w http.ResponseWriter, r *http.Request,
 	w.WriteHeader(status)
 
 	data["T"] = T
+	data["Multi"] = srv.Roster != nil
 	if err := srv.templates.Funcs(map[string]interface{}{"T": T}).ExecuteTemplate(w, template, data); err != nil {
 		srv.Logger.Print(err)
 	}
@@ -189,6 +350,13 @@ func (srv *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	password := r.Form.Get("password")
+
+	if srv.Roster != nil {
+		srv.loginContestant(w, r, r.Form.Get("username"), password)
+		return
+	}
+
 	contestFile, _, err := r.FormFile("contest")
 	if err != nil {
 		srv.errorHandler(err, w, r)
@@ -196,8 +364,6 @@ func (srv *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer contestFile.Close()
 
-	password := r.Form.Get("password")
-
 	db, err := OpenDatabase(contestFile, srv.DatabasePath)
 	if err != nil {
 		srv.errorHandler(err, w, r)
@@ -214,6 +380,7 @@ func (srv *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	if auth {
 		s.SetPassword([]byte(password))
 		s.SetDatabase(db)
+		s.FirstLogin()
 		http.Redirect(w, r, "/", http.StatusFound)
 	} else {
 		db.Clear()
@@ -221,6 +388,36 @@ func (srv *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// loginContestant logs in a contestant of the roster, in the multi-contestant
+// mode, into their own session using the shared database.
+func (srv *Server) loginContestant(w http.ResponseWriter, r *http.Request, username, password string) {
+	if _, ok := srv.Roster.Authenticate(username, password); !ok {
+		http.Redirect(w, r, "/?wrong=true", http.StatusFound)
+		return
+	}
+
+	s, err := srv.sessionManager.OpenUserSession(w, r, username)
+	if err != nil {
+		srv.errorHandler(err, w, r)
+		return
+	}
+
+	db, key := srv.sharedContest()
+	s.SetPassword(key)
+	s.SetDatabase(db)
+	s.FirstLogin()
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// sharedContest returns the database shared by the contestants of the roster
+// and its password, which may be replaced by the admin at any moment.
+func (srv *Server) sharedContest() (*Database, []byte) {
+	srv.contestLock.Lock()
+	defer srv.contestLock.Unlock()
+
+	return srv.Contest, srv.ContestPassword
+}
+
 // logout handler
 func (srv *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
 	srv.sessionManager.DeleteSession(w, r)
@@ -295,6 +492,12 @@ func (srv *Server) taskHandler(s *Session, w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	contest, err := s.GetDatabase().Contest()
+	if err != nil {
+		srv.errorHandler(err, w, r)
+		return
+	}
+
 	srv.render(w, r, "task.html", map[string]interface{}{
 		"PageID":        task.Name,
 		"Title":         task.Title,
@@ -305,6 +508,7 @@ func (srv *Server) taskHandler(s *Session, w http.ResponseWriter, r *http.Reques
 		"HasHTML":       len(statement.HTML) > 0,
 		"HTMLStatement": template.HTML(string(statement.HTML)),
 		"Langs":         AllLanguages,
+		"FullOption":    contest.FullEvaluationOption && !contest.FullEvaluation,
 	}, http.StatusOK)
 }
 
@@ -326,6 +530,13 @@ func (srv *Server) submitHandler(s *Session, w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	contest, err := s.GetDatabase().Contest()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
 	err = r.ParseMultipartForm(32 << 20)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -333,6 +544,47 @@ func (srv *Server) submitHandler(s *Session, w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	now := time.Now()
+	timer := contest.Timer(s.FirstLogin())
+	if err := timer.Check(now); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	// asking for a full evaluation multiplies the load of the judge, so it's
+	// ignored unless the contest allows it
+	fullEvaluation := contest.FullEvaluation || (contest.FullEvaluationOption && r.Form.Get("full") == "on")
+
+	if task.Type == TaskTypeOutputOnly {
+		outputs, err := readOutputs(r.MultipartForm.File["outputs"])
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			encoder.Encode(result{err.Error(), 0})
+			return
+		}
+
+		subID, err := srv.Judge.SendSubmission(Submission{
+			SID:            s.GetID(),
+			When:           now,
+			Elapsed:        timer.Elapsed(now),
+			Task:           &task,
+			DB:             s.GetDatabase(),
+			Key:            s.GetPassword(),
+			Outputs:        outputs,
+			FullEvaluation: fullEvaluation,
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			encoder.Encode(result{err.Error(), 0})
+			return
+		}
+
+		srv.trackPending(s, subID)
+		encoder.Encode(result{"", subID})
+		return
+	}
+
 	file, _, err := r.FormFile("file")
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -367,18 +619,246 @@ func (srv *Server) submitHandler(s *Session, w http.ResponseWriter, r *http.Requ
 
 	lang := AllLanguages[langIndex]
 
-	subID := srv.Judge.SendSubmission(Submission{
-		SID:  s.GetID(),
-		When: time.Now(),
-		Task: &task,
-		Code: code,
-		Lang: lang,
-		DB:   s.GetDatabase(),
-		Key:  s.GetPassword(),
+	subID, err := srv.Judge.SendSubmission(Submission{
+		SID:            s.GetID(),
+		When:           now,
+		Elapsed:        timer.Elapsed(now),
+		Task:           &task,
+		Code:           code,
+		Lang:           lang,
+		DB:             s.GetDatabase(),
+		Key:            s.GetPassword(),
+		FullEvaluation: fullEvaluation,
 	})
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	srv.trackPending(s, subID)
+	s.AddSubmittedCode(task.Name, CodeInfo{Code: string(code), Lang: langIndex}, subID)
+
 	encoder.Encode(result{"", subID})
 }
 
+// visibleVerdict returns what the contestant may see of a verdict: results
+// frozen until the end of the contest are hidden, and the remaining ones
+// reveal only what the contest feedback level allows.
+func (srv *Server) visibleVerdict(s *Session, contest ContestData, v TaskVerdict) TaskVerdict {
+	if contest.Timer(s.FirstLogin()).Frozen(v.When, time.Now()) {
+		return v.WithFreeze()
+	}
+	return v.WithFeedback(contest.Feedback)
+}
+
+// getScoreHandler reports the score of the session in each task and in the
+// whole contest, leaving out the results still frozen.
+func (srv *Server) getScoreHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	contest, err := s.GetDatabase().Contest()
+	if err != nil {
+		srv.errorHandler(err, w, r)
+		return
+	}
+
+	verdicts := s.GetSubmissions()
+	for i := range verdicts {
+		verdicts[i] = srv.visibleVerdict(s, contest, verdicts[i])
+	}
+
+	json.NewEncoder(w).Encode(contest.Score(verdicts))
+}
+
+// scoreboard ranks the contestants of the roster, with the results each of
+// them has frozen left out.
+func (srv *Server) scoreboard() (Scoreboard, error) {
+	db, _ := srv.sharedContest()
+	contest, err := db.Contest()
+	if err != nil {
+		return Scoreboard{}, err
+	}
+
+	sessions := srv.sessionManager.UserSessions()
+
+	scoreboard := Scoreboard{Title: contest.Title}
+	for _, c := range srv.Roster.Contestants {
+		var verdicts []TaskVerdict
+		if s, ok := sessions[c.Username]; ok {
+			verdicts = s.GetSubmissions()
+			for i := range verdicts {
+				verdicts[i] = srv.visibleVerdict(s, contest, verdicts[i])
+			}
+		}
+
+		scoreboard.Entries = append(scoreboard.Entries, ScoreboardEntry{
+			ContestScore: contest.Score(verdicts),
+			Username:     c.Username,
+			Name:         c.Name,
+		})
+	}
+
+	scoreboard.Tasks = contest.Score(nil).Tasks
+	scoreboard.rank()
+
+	return scoreboard, nil
+}
+
+func (srv *Server) scoreboardHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	if srv.Roster == nil {
+		srv.notFoundHandler(w, r)
+		return
+	}
+
+	contest, err := s.GetDatabase().Contest()
+	if err != nil {
+		srv.errorHandler(err, w, r)
+		return
+	}
+
+	srv.render(w, r, "scoreboard.html", map[string]interface{}{
+		"PageID": "_scoreboard",
+		"Title":  contest.Title,
+		"Tasks":  contest.Tasks,
+		"Refs":   srv.Reference.Data,
+	}, http.StatusOK)
+}
+
+func (srv *Server) getScoreboardHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	if srv.Roster == nil {
+		srv.notFoundHandler(w, r)
+		return
+	}
+
+	scoreboard, err := srv.scoreboard()
+	if err != nil {
+		srv.errorHandler(err, w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(scoreboard)
+}
+
+// getTimerHandler reports the state of the contest timer of the session.
+func (srv *Server) getTimerHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Started   bool
+		Ended     bool
+		Frozen    bool
+		Limited   bool
+		Remaining time.Duration
+		Elapsed   time.Duration
+	}
+
+	contest, err := s.GetDatabase().Contest()
+	if err != nil {
+		srv.errorHandler(err, w, r)
+		return
+	}
+
+	now := time.Now()
+	timer := contest.Timer(s.FirstLogin())
+
+	json.NewEncoder(w).Encode(result{
+		Started:   !now.Before(timer.Start),
+		Ended:     timer.Ended(now),
+		Frozen:    timer.Frozen(now, now),
+		Limited:   !timer.End.IsZero(),
+		Remaining: timer.Remaining(now),
+		Elapsed:   timer.Elapsed(now),
+	})
+}
+
+// ownSubmission returns the ID in the request path, as long as it refers to a
+// submission sent by the session.
+func (srv *Server) ownSubmission(s *Session, r *http.Request) (uint32, error) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	sub, ok := srv.Judge.Submission(uint32(id))
+	if !ok || sub.SID != s.GetID() {
+		return 0, errors.New("Submission " + strconv.Itoa(int(id)) + " doesn't exist")
+	}
+
+	return uint32(id), nil
+}
+
+func (srv *Server) cancelHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Error string
+		ID    uint32
+	}
+
+	encoder := json.NewEncoder(w)
+
+	id, err := srv.ownSubmission(s, r)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	err = srv.Judge.Cancel(id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	encoder.Encode(result{"", id})
+}
+
+func (srv *Server) rejudgeHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Error string
+		ID    uint32
+	}
+
+	encoder := json.NewEncoder(w)
+
+	id, err := srv.ownSubmission(s, r)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	err = srv.rejudge(s, id)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	encoder.Encode(result{"", id})
+}
+
+// rejudge judges a submission of the session again, with the database the
+// session uses now.
+func (srv *Server) rejudge(s *Session, id uint32) error {
+	// the previous verdict is removed beforehand, as the new one could arrive
+	// right after the submission is queued again
+	previous := s.RemoveSubmission(int(id))
+
+	err := srv.Judge.Rejudge(id, s.GetDatabase())
+	if err != nil {
+		s.RestoreSubmission(previous)
+		return err
+	}
+
+	srv.trackPending(s, id)
+	return nil
+}
+
+// trackPending stores a submission just sent to the judge in the session, so
+// it's judged again if the program restarts before its verdict arrives.
+func (srv *Server) trackPending(s *Session, id uint32) {
+	if sub, ok := srv.Judge.Submission(id); ok {
+		s.AddPending(sub)
+	}
+}
+
 func (srv *Server) testHandler(s *Session, w http.ResponseWriter, r *http.Request) {
 	type result struct {
 		Error string
@@ -433,17 +913,250 @@ func (srv *Server) testHandler(s *Session, w http.ResponseWriter, r *http.Reques
 
 	input := []byte(r.Form.Get("input"))
 
-	testID := srv.Judge.SendCustomTest(CustomTest{
+	testID, err := srv.Judge.SendCustomTest(CustomTest{
 		SID:      s.GetID(),
 		When:     time.Now(),
 		TaskName: name,
 		Input:    input,
 		Code:     code,
 		Lang:     lang,
+		DB:       s.GetDatabase(),
+		Key:      s.GetPassword(),
 	})
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
 	encoder.Encode(result{"", testID})
 }
 
+// stressHandler starts a stress test of the code in the editor against a
+// brute force solution, over the inputs of a generator.
+func (srv *Server) stressHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Error string
+		ID    uint32
+	}
+
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	encoder := json.NewEncoder(w)
+
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil && err != http.ErrNotMultipart {
+		w.WriteHeader(http.StatusInternalServerError)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	program := func(codeKey, langKey string) (StressProgram, error) {
+		code := []byte(r.Form.Get(codeKey))
+		if len(code) == 0 {
+			return StressProgram{}, errors.New("The " + codeKey + " code is empty")
+		} else if len(code) > (1 << 20) { // 1MB
+			return StressProgram{}, errors.New("Code length (" + strconv.Itoa(len(code)) + ") exceeds 1MB!")
+		}
+
+		langIndex, err := strconv.Atoi(r.Form.Get(langKey))
+		if err != nil || langIndex < 0 || langIndex >= len(AllLanguages) {
+			return StressProgram{}, errors.New("Language " + r.Form.Get(langKey) + " doesn't have a runner!")
+		}
+
+		return StressProgram{code, AllLanguages[langIndex]}, nil
+	}
+
+	t := StressTest{
+		SID:      s.GetID(),
+		When:     time.Now(),
+		TaskName: name,
+		DB:       s.GetDatabase(),
+		Key:      s.GetPassword(),
+	}
+
+	t.Runs, _ = strconv.Atoi(r.Form.Get("runs"))
+
+	for _, p := range []struct {
+		program       *StressProgram
+		codeKey, lang string
+	}{
+		{&t.Generator, "generator", "generatorlang"},
+		{&t.Brute, "brute", "brutelang"},
+		{&t.Candidate, "code", "lang"},
+	} {
+		*p.program, err = program(p.codeKey, p.lang)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			encoder.Encode(result{err.Error(), 0})
+			return
+		}
+	}
+
+	stressID, err := srv.Judge.SendStressTest(t)
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		encoder.Encode(result{err.Error(), 0})
+		return
+	}
+
+	encoder.Encode(result{"", stressID})
+}
+
+func (srv *Server) getStressHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.FormValue("id"))
+	json.NewEncoder(w).Encode(s.GetStress(id))
+}
+
+// readOutputs reads the outputs uploaded to an output-only task, either as
+// separate files or inside zip archives, mapping each one to the test number
+// found at the end of its name (e.g. 3.out or output_03.txt).
+func readOutputs(headers []*multipart.FileHeader) (map[int][]byte, error) {
+	outputs := make(map[int][]byte)
+
+	add := func(name string, content []byte) error {
+		n, err := outputNumber(name)
+		if err != nil {
+			return err
+		}
+
+		outputs[n] = content
+		return nil
+	}
+
+	for _, header := range headers {
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.ToLower(filepath.Ext(header.Filename)) != ".zip" {
+			if err := add(header.Filename, content); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range archive.File {
+			if strings.HasSuffix(f.Name, "/") {
+				continue
+			}
+
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+
+			content, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+
+			if err := add(f.Name, content); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(outputs) == 0 {
+		return nil, errors.New("No output files were sent!")
+	}
+
+	return outputs, nil
+}
+
+func outputNumber(name string) (int, error) {
+	base := filepath.Base(name)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	digits := strings.TrimRightFunc(base, unicode.IsDigit)
+
+	n, err := strconv.Atoi(base[len(digits):])
+	if err != nil {
+		return 0, errors.New("File " + name + " doesn't indicate a test number!")
+	}
+
+	return n, nil
+}
+
+func (srv *Server) inputsHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	task, err := s.GetDatabase().Task(name)
+	if err != nil {
+		srv.errorHandler(err, w, r)
+		return
+	}
+
+	if task.Type != TaskTypeOutputOnly {
+		err = errors.New("Inputs of " + name + " are not available.")
+		srv.errorHandler(err, w, r)
+		return
+	}
+
+	tests, err := s.GetDatabase().Tests(name, s.GetPassword())
+	if err != nil {
+		srv.errorHandler(err, w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+name+".zip\"")
+
+	archive := zip.NewWriter(w)
+	for _, test := range tests {
+		f, err := archive.Create(strconv.Itoa(test.N) + ".in")
+		if err != nil {
+			srv.Logger.Print(err)
+			return
+		}
+
+		if _, err := f.Write(test.Input); err != nil {
+			srv.Logger.Print(err)
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		srv.Logger.Print(err)
+	}
+}
+
+// exportHandler sends a zip with the submissions, the code and the results
+// of the session, so contestants can keep their work after the contest.
+func (srv *Server) exportHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	contest, err := s.GetDatabase().Contest()
+	if err != nil {
+		srv.errorHandler(err, w, r)
+		return
+	}
+
+	verdicts := s.GetSubmissions()
+	for i := range verdicts {
+		verdicts[i] = srv.visibleVerdict(s, contest, verdicts[i])
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+exportName(contest.Name)+".zip\"")
+
+	if err := WriteExport(w, verdicts, s.GetCodes()); err != nil {
+		srv.Logger.Print(err)
+	}
+}
+
 func (srv *Server) pdfHandler(s *Session, w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	name := vars["name"]
@@ -479,9 +1192,129 @@ func (srv *Server) getSubmissionHandler(s *Session, w http.ResponseWriter, r *ht
 		}
 	}
 
+	contest, err := s.GetDatabase().Contest()
+	if err != nil {
+		srv.errorHandler(err, w, r)
+		return
+	}
+
+	for i := range subs {
+		subs[i] = srv.visibleVerdict(s, contest, subs[i])
+	}
+
 	encoder.Encode(subs)
 }
 
+// getProgressHandler reports the progress of the submissions of a task, or
+// of a single one, which are not judged yet
+func (srv *Server) getProgressHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	encoder := json.NewEncoder(w)
+
+	var progress []SubmissionProgress
+
+	task := r.FormValue("task")
+	if len(task) > 0 {
+		progress = s.GetProgress(task)
+	} else {
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			encoder.Encode(progress)
+			return
+		}
+		progress = s.GetSubmissionProgress(id)
+	}
+
+	for i := range progress {
+		if progress[i].State == ProgressQueued {
+			progress[i].Position = srv.Judge.Position(progress[i].ID)
+		}
+	}
+
+	encoder.Encode(progress)
+}
+
+// eventsHandler streams the verdicts and progress updates of the session as
+// Server-Sent Events, resuming after the Last-Event-ID sent by reconnecting
+// clients
+func (srv *Server) eventsHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		srv.errorHandler(errors.New("Streaming is not supported"), w, r)
+		return
+	}
+
+	contest, err := s.GetDatabase().Contest()
+	if err != nil {
+		srv.errorHandler(err, w, r)
+		return
+	}
+
+	// new clients fetch the current state by themselves, so only the events
+	// from now on are sent to them, while reconnecting ones resume from the
+	// last one they have seen. Progress events aren't stored, so a client
+	// connected before a restart may have seen IDs now being reused, in
+	// which case everything still kept is sent again.
+	lastID := s.LastEventID()
+	if id := r.Header.Get("Last-Event-ID"); len(id) > 0 {
+		seen, err := strconv.ParseUint(id, 10, 64)
+		if err != nil || seen > lastID {
+			seen = 0
+		}
+		lastID = seen
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		events, changed := s.Events(lastID)
+		for _, event := range events {
+			var data interface{}
+			switch event.Type {
+			case EventSubmission:
+				data = srv.visibleVerdict(s, contest, *event.TaskVerdict)
+			case EventTest:
+				data = event.TestVerdict
+			case EventStress:
+				data = event.StressVerdict
+			case EventClarification:
+				data = event.Clarification
+			case EventAnnouncement:
+				data = event.Announcement
+			case EventProgress:
+				progress := *event.Progress
+				if progress.State == ProgressQueued {
+					progress.Position = srv.Judge.Position(progress.ID)
+				}
+				data = progress
+			}
+
+			encoded, err := json.Marshal(data)
+			if err != nil {
+				return
+			}
+
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, encoded)
+			lastID = event.ID
+		}
+		flusher.Flush()
+
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-srv.shutdownChannel:
+			return
+		}
+	}
+}
+
 func (srv *Server) getTestHandler(s *Session, w http.ResponseWriter, r *http.Request) {
 	encoder := json.NewEncoder(w)
 
@@ -527,6 +1360,143 @@ func (srv *Server) setCode(s *Session, w http.ResponseWriter, r *http.Request) {
 	s.SetCode(task, CodeInfo{Code: code, Lang: lang})
 }
 
+// getCodeHistory lists the saved versions of the code of a task, leaving the
+// code itself out.
+func (srv *Server) getCodeHistory(s *Session, w http.ResponseWriter, r *http.Request) {
+	type version struct {
+		ID         int
+		When       time.Time
+		Lang       int
+		Submission uint32
+		Lines      int
+	}
+
+	versions := make([]version, 0)
+	for _, v := range s.GetCodeHistory(r.FormValue("task")) {
+		versions = append(versions, version{v.ID, v.When, v.Lang, v.Submission, len(codeLines(v.Code))})
+	}
+
+	json.NewEncoder(w).Encode(versions)
+}
+
+// getCodeDiff compares two codes of a task. Each side is a saved version, the
+// first one may be a submission instead and the second one defaults to the
+// code currently in the editor.
+func (srv *Server) getCodeDiff(s *Session, w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Error string
+		Lines []DiffLine
+	}
+
+	encoder := json.NewEncoder(w)
+	task := r.FormValue("task")
+
+	code := func(key string) (string, error) {
+		id, err := strconv.Atoi(r.FormValue(key))
+		if err != nil {
+			return "", errors.New("Invalid version " + r.FormValue(key))
+		}
+
+		v, ok := s.GetCodeVersion(task, id)
+		if !ok {
+			return "", errors.New("Version " + strconv.Itoa(id) + " doesn't exist")
+		}
+		return v.Code, nil
+	}
+
+	var from, to string
+	var err error
+
+	if len(r.FormValue("submission")) > 0 {
+		id, _ := strconv.Atoi(r.FormValue("submission"))
+		subs := s.GetSubmission(id)
+		if len(subs) == 0 || subs[0].TaskName != task {
+			w.WriteHeader(http.StatusNotFound)
+			encoder.Encode(result{"Submission " + r.FormValue("submission") + " doesn't exist", nil})
+			return
+		}
+		from = subs[0].Code
+	} else if from, err = code("from"); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		encoder.Encode(result{err.Error(), nil})
+		return
+	}
+
+	if len(r.FormValue("to")) == 0 {
+		to = s.GetCode(task).Code
+	} else if to, err = code("to"); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		encoder.Encode(result{err.Error(), nil})
+		return
+	}
+
+	encoder.Encode(result{"", Diff(from, to)})
+}
+
+// restoreCode replaces the code of a task by a saved version, returning it so
+// it can be put in the editor.
+func (srv *Server) restoreCode(s *Session, w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Error string
+		Code  CodeInfo
+	}
+
+	encoder := json.NewEncoder(w)
+
+	id, _ := strconv.Atoi(r.FormValue("version"))
+	code, ok := s.RestoreCode(r.FormValue("task"), id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		encoder.Encode(result{"Version " + r.FormValue("version") + " doesn't exist", code})
+		return
+	}
+
+	encoder.Encode(result{"", code})
+}
+
+// clarificationHandler sends a question to the contest admin, either about a
+// task or about the contest as a whole if no task is given.
+func (srv *Server) clarificationHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Error         string
+		Clarification Clarification
+	}
+
+	encoder := json.NewEncoder(w)
+
+	task := r.FormValue("task")
+	if len(task) > 0 {
+		if _, err := s.GetDatabase().Task(task); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			encoder.Encode(result{err.Error(), Clarification{}})
+			return
+		}
+	}
+
+	c, err := srv.sessionManager.AskClarification(s, task, strings.TrimSpace(r.FormValue("question")))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encoder.Encode(result{err.Error(), c})
+		return
+	}
+
+	encoder.Encode(result{"", c})
+}
+
+// getClarificationsHandler returns the questions asked by the session and
+// the announcements sent to everybody.
+func (srv *Server) getClarificationsHandler(s *Session, w http.ResponseWriter, r *http.Request) {
+	type result struct {
+		Clarifications []Clarification
+		Announcements  []Announcement
+	}
+
+	json.NewEncoder(w).Encode(result{
+		Clarifications: s.GetClarifications(),
+		Announcements:  srv.sessionManager.Announcements(),
+	})
+}
+
 func (srv *Server) localeWrapper(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		newLocale := r.FormValue("locale")
@@ -559,6 +1529,12 @@ func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
 	return size, err
 }
 
+func (lrw *loggingResponseWriter) Flush() {
+	if flusher, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
 func (srv *Server) loggingWrapper(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		lrw := &loggingResponseWriter{w, http.StatusOK, 0}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRestoreSessionsKeepsContest checks that restoring the sessions of a
+// previous run removes the databases left behind, but not the database of the
+// roster copied into the same folder before the restore.
+func TestRestoreSessionsKeepsContest(t *testing.T) {
+	folder, err := ioutil.TempDir("", "obijudge-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	contestPath := filepath.Join(folder, "contest")
+	stalePath := filepath.Join(folder, "stale")
+	for _, path := range []string{contestPath, stalePath} {
+		if err := ioutil.WriteFile(path, []byte("zip"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := &Server{
+		DatabasePath:   folder,
+		Roster:         &Roster{},
+		Contest:        &Database{path: contestPath},
+		sessionManager: NewSessionManager(nil, nil, nil, nil, "obijudge-test"),
+	}
+
+	if err := srv.restoreSessions(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(contestPath); err != nil {
+		t.Errorf("the copied contest was removed: %v", err)
+	}
+	if _, err := os.Stat(stalePath); !os.IsNotExist(err) {
+		t.Errorf("the stale database was kept: %v", err)
+	}
+}
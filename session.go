@@ -1,9 +1,14 @@
 package main
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
 	"net/http"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,22 +20,91 @@ import (
 type SessionManager struct {
 	cookieName         string
 	sessions           map[string]*Session
+	hashKey            []byte
+	blockKey           []byte
 	secureCookie       *securecookie.SecureCookie
 	taskVerdictChannel <-chan TaskVerdict
 	testVerdictChannel <-chan CustomTestVerdict
+	progressChannel    <-chan SubmissionProgress
 	watcherStopChannel chan bool
+	store              *sessionStore
 	lock               sync.Mutex
+
+	announcements   []Announcement
+	announcementID  uint32
+	clarificationID uint32
+
+	stressVerdictChannel <-chan StressVerdict
 }
 
 // Session stores information related to a single user session.
 type Session struct {
 	sid          string
+	user         string
 	password     []byte
 	database     *Database
 	taskVerdicts []TaskVerdict
 	testVerdicts []CustomTestVerdict
+	progress     map[uint32]SubmissionProgress
 	codes        map[string]CodeInfo
+	history      map[string][]CodeVersion
+	firstLogin   time.Time
+	store        *sessionStore
 	lock         sync.Mutex
+
+	clarifications []Clarification
+
+	// pending keeps the submissions not judged yet, so they can be judged
+	// after a restart
+	pending []PendingSubmission
+
+	// stressVerdicts keeps the latest stress tests only, and isn't stored, as
+	// each one may hold a large input
+	stressVerdicts []StressVerdict
+
+	// events keeps the latest updates pushed to the browser, so reconnecting
+	// clients can resume from the last one they have seen, while
+	// eventsChanged is closed whenever a new one arrives
+	events        []SessionEvent
+	lastEventID   uint64
+	eventsChanged chan struct{}
+
+	// removed is set once the session is deleted, so it isn't stored again
+	removed bool
+}
+
+// Types of the events pushed to the browser
+const (
+	EventSubmission = "submission"
+	EventTest       = "test"
+	EventProgress   = "progress"
+
+	EventClarification = "clarification"
+	EventAnnouncement  = "announcement"
+
+	EventStress = "stress"
+)
+
+const maxSessionEvents = 1000
+
+// userSessionPrefix starts the IDs of the sessions of contestants of the
+// roster, which are followed by their username.
+const userSessionPrefix = "user-"
+
+const adminCookieSuffix = "-admin"
+
+// SessionEvent is a verdict or progress update of a session, of which only
+// the field corresponding to its type is set.
+type SessionEvent struct {
+	ID          uint64
+	Type        string
+	TaskVerdict *TaskVerdict
+	TestVerdict *CustomTestVerdict
+	Progress    *SubmissionProgress
+
+	Clarification *Clarification
+	Announcement  *Announcement
+	StressVerdict *StressVerdict
 }
 
 // CodeInfo is used to share information between Go and Javascript.
@@ -40,22 +114,27 @@ type CodeInfo struct {
 }
 
 // NewSessionManager acts as a constructor and initializes a new session manager.
-func NewSessionManager(taskVerdictChannel <-chan TaskVerdict, testVerdictChannel <-chan CustomTestVerdict, cookieName string) *SessionManager {
+func NewSessionManager(taskVerdictChannel <-chan TaskVerdict, testVerdictChannel <-chan CustomTestVerdict, stressVerdictChannel <-chan StressVerdict, progressChannel <-chan SubmissionProgress, cookieName string) *SessionManager {
 	var hashKey, blockKey []byte
 	if testingFlag {
 		hashKey = []byte("testing-key")
 		blockKey = []byte("testing-blockkey")
 	} else {
 		hashKey = securecookie.GenerateRandomKey(32)
-		hashKey = securecookie.GenerateRandomKey(32)
+		blockKey = securecookie.GenerateRandomKey(32)
 	}
 
 	m := &SessionManager{
 		cookieName:         cookieName,
+		hashKey:            hashKey,
+		blockKey:           blockKey,
 		sessions:           make(map[string]*Session),
 		taskVerdictChannel: taskVerdictChannel,
 		testVerdictChannel: testVerdictChannel,
+		progressChannel:    progressChannel,
 		secureCookie:       securecookie.New(hashKey, blockKey),
+
+		stressVerdictChannel: stressVerdictChannel,
 	}
 
 	return m
@@ -86,9 +165,14 @@ func (m *SessionManager) StartWatcher() {
 				m.lock.Lock()
 				if session, ok := m.sessions[v.SID]; ok {
 					session.lock.Lock()
+					session.removeTaskVerdict(v.ID)
+					session.removePending(v.ID)
 					session.taskVerdicts = append(session.taskVerdicts, v)
-					session.lock.Unlock()
+					delete(session.progress, v.ID)
 					sort.Sort(taskVerdictsByID(session.taskVerdicts))
+					session.addEvent(SessionEvent{Type: EventSubmission, TaskVerdict: &v})
+					session.persist()
+					session.lock.Unlock()
 				}
 				m.lock.Unlock()
 			case v := <-m.testVerdictChannel:
@@ -96,8 +180,30 @@ func (m *SessionManager) StartWatcher() {
 				if session, ok := m.sessions[v.SID]; ok {
 					session.lock.Lock()
 					session.testVerdicts = append(session.testVerdicts, v)
-					session.lock.Unlock()
 					sort.Sort(testVerdictsByID(session.testVerdicts))
+					session.addEvent(SessionEvent{Type: EventTest, TestVerdict: &v})
+					session.persist()
+					session.lock.Unlock()
+				}
+				m.lock.Unlock()
+			case v := <-m.stressVerdictChannel:
+				m.lock.Lock()
+				if session, ok := m.sessions[v.SID]; ok {
+					session.lock.Lock()
+					session.stressVerdicts = append(session.stressVerdicts, v)
+					if len(session.stressVerdicts) > maxStressVerdicts {
+						session.stressVerdicts = session.stressVerdicts[1:]
+					}
+					session.addEvent(SessionEvent{Type: EventStress, StressVerdict: &v})
+					session.lock.Unlock()
+				}
+				m.lock.Unlock()
+			case p := <-m.progressChannel:
+				m.lock.Lock()
+				if session, ok := m.sessions[p.SID]; ok {
+					session.lock.Lock()
+					session.setProgress(p)
+					session.lock.Unlock()
 				}
 				m.lock.Unlock()
 			}
@@ -105,6 +211,109 @@ func (m *SessionManager) StartWatcher() {
 	}()
 }
 
+// Persist restores the sessions stored in the specified folder, and keeps
+// storing every change to them from now on. It should be called before the
+// session manager is used.
+func (m *SessionManager) Persist(folder string) error {
+	store, err := newSessionStore(folder)
+	if err != nil {
+		return err
+	}
+
+	keys, err := store.keys(storedKeys{
+		CookieName: m.cookieName,
+		HashKey:    m.hashKey,
+		BlockKey:   m.blockKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	stored, err := store.load()
+	if err != nil {
+		return err
+	}
+
+	announcements, err := store.loadAnnouncements()
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.cookieName = keys.CookieName
+	m.hashKey = keys.HashKey
+	m.blockKey = keys.BlockKey
+	m.secureCookie = securecookie.New(keys.HashKey, keys.BlockKey)
+	m.store = store
+	store.start()
+
+	m.announcements = announcements
+	for _, a := range announcements {
+		if a.ID > m.announcementID {
+			m.announcementID = a.ID
+		}
+	}
+
+	for _, st := range stored {
+		session := &Session{
+			sid:          st.SID,
+			user:         st.User,
+			password:     st.Password,
+			taskVerdicts: st.TaskVerdicts,
+			testVerdicts: st.TestVerdicts,
+			progress:     make(map[uint32]SubmissionProgress),
+			codes:        st.Codes,
+			history:      st.History,
+			firstLogin:   st.FirstLogin,
+			store:        store,
+
+			clarifications: st.Clarifications,
+			lastEventID:    st.LastEventID,
+			pending:        st.Pending,
+		}
+
+		if session.codes == nil {
+			session.codes = make(map[string]CodeInfo)
+		}
+		if session.history == nil {
+			session.history = make(map[string][]CodeVersion)
+		}
+
+		// without its database, the contestant has to log in again
+		if len(st.DatabasePath) > 0 {
+			session.database, err = LoadDatabase(st.DatabasePath)
+			if err != nil {
+				log.Print("Can't restore session database: ", err)
+				session.password = nil
+			}
+		}
+
+		for _, c := range session.clarifications {
+			if c.ID > m.clarificationID {
+				m.clarificationID = c.ID
+			}
+		}
+
+		m.sessions[st.SID] = session
+	}
+
+	return nil
+}
+
+// Sessions returns all the open sessions.
+func (m *SessionManager) Sessions() []*Session {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ret := make([]*Session, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		ret = append(ret, session)
+	}
+	return ret
+}
+
 func (m *SessionManager) StopWatcher() {
 	if m.watcherStopChannel == nil {
 		return
@@ -113,6 +322,14 @@ func (m *SessionManager) StopWatcher() {
 	m.watcherStopChannel <- true
 }
 
+// Close stores the sessions changed since they were last stored, and stops
+// storing them. It should be called once the sessions are no longer used.
+func (m *SessionManager) Close() {
+	if m.store != nil {
+		m.store.stop()
+	}
+}
+
 func (m *SessionManager) getSessionID(r *http.Request) string {
 	if cookie, err := r.Cookie(m.cookieName); err == nil {
 		var sid string
@@ -166,8 +383,11 @@ func (m *SessionManager) OpenSession(w http.ResponseWriter, r *http.Request) (*S
 	}
 
 	session := &Session{
-		sid:   string(sid),
-		codes: make(map[string]CodeInfo),
+		sid:      string(sid),
+		progress: make(map[uint32]SubmissionProgress),
+		codes:    make(map[string]CodeInfo),
+		history:  make(map[string][]CodeVersion),
+		store:    m.store,
 	}
 
 	m.sessions[sid] = session
@@ -175,16 +395,141 @@ func (m *SessionManager) OpenSession(w http.ResponseWriter, r *http.Request) (*S
 	return session, nil
 }
 
-func (m *SessionManager) DeleteSession(w http.ResponseWriter, r *http.Request) {
+// OpenUserSession makes the request use the session of a contestant of the
+// roster, creating it if needed. Every contestant has a single session, kept
+// after logging out, so they find their work again from any computer.
+func (m *SessionManager) OpenUserSession(w http.ResponseWriter, r *http.Request, user string) (*Session, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
+	// the session used before logging in isn't needed anymore
 	if sid := m.getSessionID(r); len(sid) > 0 {
+		if session, ok := m.sessions[sid]; ok && len(session.GetUser()) == 0 && len(session.GetPassword()) == 0 {
+			delete(m.sessions, sid)
+		}
+	}
+
+	sid := userSessionPrefix + user
+	if err := m.setSessionID(w, sid); err != nil {
+		return nil, err
+	}
+
+	if session, ok := m.sessions[sid]; ok {
+		return session, nil
+	}
+
+	session := &Session{
+		sid:      sid,
+		user:     user,
+		progress: make(map[uint32]SubmissionProgress),
+		codes:    make(map[string]CodeInfo),
+		history:  make(map[string][]CodeVersion),
+		store:    m.store,
+	}
+
+	m.sessions[sid] = session
+
+	return session, nil
+}
+
+// GetSession returns the session with the specified ID.
+func (m *SessionManager) GetSession(sid string) (*Session, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	session, ok := m.sessions[sid]
+	return session, ok
+}
+
+// SetAdmin marks the browser as logged in the admin area with the specified
+// password, or logs it out when the password is empty.
+func (m *SessionManager) SetAdmin(w http.ResponseWriter, password string) error {
+	if len(password) == 0 {
+		http.SetCookie(w, &http.Cookie{
+			Name:    m.cookieName + adminCookieSuffix,
+			Value:   "",
+			Path:    "/",
+			MaxAge:  -1,
+			Expires: time.Unix(0, 0),
+		})
+		return nil
+	}
+
+	encoded, err := m.secureCookie.Encode(m.cookieName+adminCookieSuffix, adminToken(password))
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookieName + adminCookieSuffix,
+		Value:    encoded,
+		Path:     "/",
+		HttpOnly: true,
+	})
+
+	return nil
+}
+
+// IsAdmin reports whether the browser has logged in the admin area with the
+// specified password, so changing it logs everybody out.
+func (m *SessionManager) IsAdmin(r *http.Request, password string) bool {
+	cookie, err := r.Cookie(m.cookieName + adminCookieSuffix)
+	if err != nil {
+		return false
+	}
+
+	var token string
+	if err := m.secureCookie.Decode(m.cookieName+adminCookieSuffix, cookie.Value, &token); err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(adminToken(password))) == 1
+}
+
+func adminToken(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// UserSessions returns the sessions of the contestants of the roster, by
+// username.
+func (m *SessionManager) UserSessions() map[string]*Session {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	ret := make(map[string]*Session)
+	for _, session := range m.sessions {
+		if user := session.GetUser(); len(user) > 0 {
+			ret[user] = session
+		}
+	}
+	return ret
+}
+
+func (m *SessionManager) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	// sessions of contestants of the roster are kept, as well as the database
+	// they share, so only the cookie is removed
+	if sid := m.getSessionID(r); len(sid) > 0 && !strings.HasPrefix(sid, userSessionPrefix) {
 		if session, ok := m.sessions[sid]; ok {
-			session.GetDatabase().Clear()
+			if session.GetDatabase() != nil {
+				session.GetDatabase().Clear()
+			}
+
+			session.lock.Lock()
+			session.removed = true
+			session.lock.Unlock()
 		}
 
 		delete(m.sessions, sid)
+
+		if m.store != nil {
+			if err := m.store.remove(sid); err != nil {
+				log.Print("Can't remove stored session: ", err)
+			}
+		}
 	}
 
 	http.SetCookie(w, &http.Cookie{
@@ -196,6 +541,15 @@ func (m *SessionManager) DeleteSession(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// GetUser returns the username of the contestant of the session, which is
+// empty unless running in the multi-contestant mode.
+func (s *Session) GetUser() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.user
+}
+
 func (s *Session) GetID() string {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -215,6 +569,20 @@ func (s *Session) SetPassword(password []byte) {
 	defer s.lock.Unlock()
 
 	s.password = password
+	s.persist()
+}
+
+// FirstLogin returns when the contestant has logged in for the first time,
+// which is now if it hasn't been recorded yet.
+func (s *Session) FirstLogin() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.firstLogin.IsZero() {
+		s.firstLogin = time.Now()
+		s.persist()
+	}
+	return s.firstLogin
 }
 
 func (s *Session) GetDatabase() *Database {
@@ -229,6 +597,7 @@ func (s *Session) SetDatabase(database *Database) {
 	defer s.lock.Unlock()
 
 	s.database = database
+	s.persist()
 }
 
 func (s *Session) GetTaskSubmissions(taskName string) []TaskVerdict {
@@ -266,6 +635,217 @@ func (s *Session) GetSubmission(id int) []TaskVerdict {
 	return ret
 }
 
+// RemoveSubmission removes the verdict of a submission which is about to be
+// judged again, returning it.
+func (s *Session) RemoveSubmission(id int) []TaskVerdict {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	removed := s.removeTaskVerdict(uint32(id))
+	s.persist()
+	return removed
+}
+
+// RestoreSubmission puts back verdicts removed by RemoveSubmission, unless a
+// new verdict for the same submission has arrived in the meantime.
+func (s *Session) RestoreSubmission(verdicts []TaskVerdict) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+next:
+	for _, v := range verdicts {
+		for _, current := range s.taskVerdicts {
+			if current.ID == v.ID {
+				continue next
+			}
+		}
+		s.taskVerdicts = append(s.taskVerdicts, v)
+	}
+	sort.Sort(taskVerdictsByID(s.taskVerdicts))
+	s.persist()
+}
+
+// PendingSubmission is a submission waiting or being judged, stored with
+// everything needed to judge it again.
+type PendingSubmission struct {
+	ID             uint32
+	When           time.Time
+	Elapsed        time.Duration
+	TaskName       string
+	Code           []byte
+	LangName       string
+	Outputs        map[int][]byte
+	FullEvaluation bool
+}
+
+// AddPending records a submission sent to the judge, unless its verdict has
+// already arrived.
+func (s *Session) AddPending(sub Submission) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, v := range s.taskVerdicts {
+		if v.ID == sub.ID {
+			return
+		}
+	}
+
+	p := PendingSubmission{
+		ID:             sub.ID,
+		When:           sub.When,
+		Elapsed:        sub.Elapsed,
+		TaskName:       sub.Task.Name,
+		Code:           sub.Code,
+		Outputs:        sub.Outputs,
+		FullEvaluation: sub.FullEvaluation,
+	}
+	if sub.Lang != nil {
+		p.LangName = sub.Lang.Name()
+	}
+
+	s.removePending(sub.ID)
+	s.pending = append(s.pending, p)
+	s.persist()
+}
+
+// GetPending returns the submissions not judged yet.
+func (s *Session) GetPending() []PendingSubmission {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]PendingSubmission(nil), s.pending...)
+}
+
+// FailPending replaces a submission which can't be judged again by an error
+// verdict, so it doesn't stay pending forever.
+func (s *Session) FailPending(p PendingSubmission, extra string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.removePending(p.ID)
+	s.removeTaskVerdict(p.ID)
+	s.taskVerdicts = append(s.taskVerdicts, TaskVerdict{
+		VerdictInfo: VerdictInfo{
+			ID:       p.ID,
+			SID:      s.sid,
+			When:     p.When,
+			TaskName: p.TaskName,
+			Code:     string(p.Code),
+			LangName: p.LangName,
+		},
+		Elapsed: p.Elapsed,
+		Error:   true,
+		Extra:   extra,
+	})
+	sort.Sort(taskVerdictsByID(s.taskVerdicts))
+	s.persist()
+}
+
+func (s *Session) removePending(id uint32) {
+	for i, p := range s.pending {
+		if p.ID == id {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *Session) removeTaskVerdict(id uint32) []TaskVerdict {
+	for i, v := range s.taskVerdicts {
+		if v.ID == id {
+			s.taskVerdicts = append(s.taskVerdicts[:i], s.taskVerdicts[i+1:]...)
+			return []TaskVerdict{v}
+		}
+	}
+	return nil
+}
+
+// setProgress stores the progress of a submission, unless it has already
+// been judged, as progress may arrive after the verdict.
+func (s *Session) setProgress(p SubmissionProgress) {
+	for _, v := range s.taskVerdicts {
+		if v.ID == p.ID {
+			return
+		}
+	}
+
+	s.progress[p.ID] = p
+	s.addEvent(SessionEvent{Type: EventProgress, Progress: &p})
+}
+
+// addEvent stores a new event and wakes up everyone waiting for it. It
+// should be called with the session lock held.
+func (s *Session) addEvent(event SessionEvent) {
+	s.lastEventID++
+	event.ID = s.lastEventID
+
+	s.events = append(s.events, event)
+	if len(s.events) > maxSessionEvents {
+		s.events = s.events[len(s.events)-maxSessionEvents:]
+	}
+
+	if s.eventsChanged != nil {
+		close(s.eventsChanged)
+	}
+	s.eventsChanged = make(chan struct{})
+}
+
+// LastEventID returns the ID of the latest event of the session.
+func (s *Session) LastEventID() uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.lastEventID
+}
+
+// Events returns the events after the specified one, along with a channel
+// which is closed when new events arrive.
+func (s *Session) Events(after uint64) ([]SessionEvent, <-chan struct{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.eventsChanged == nil {
+		s.eventsChanged = make(chan struct{})
+	}
+
+	ret := make([]SessionEvent, 0)
+	for _, event := range s.events {
+		if event.ID > after {
+			ret = append(ret, event)
+		}
+	}
+	return ret, s.eventsChanged
+}
+
+// GetProgress returns the progress of the submissions to a task which are
+// not judged yet.
+func (s *Session) GetProgress(taskName string) []SubmissionProgress {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ret := make([]SubmissionProgress, 0)
+	for _, p := range s.progress {
+		if p.TaskName == taskName {
+			ret = append(ret, p)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+// GetSubmissionProgress returns the progress of a submission which is not
+// judged yet.
+func (s *Session) GetSubmissionProgress(id int) []SubmissionProgress {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ret := make([]SubmissionProgress, 0)
+	if p, ok := s.progress[uint32(id)]; ok {
+		ret = append(ret, p)
+	}
+	return ret
+}
+
 func (s *Session) GetTests() []CustomTestVerdict {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -288,11 +868,128 @@ func (s *Session) GetTest(id int) []CustomTestVerdict {
 	return ret
 }
 
+// GetStress returns the verdict of a stress test, if it has finished.
+func (s *Session) GetStress(id int) []StressVerdict {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	ret := make([]StressVerdict, 0)
+	for _, v := range s.stressVerdicts {
+		if int(v.ID) == id {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
 func (s *Session) SetCode(task string, code CodeInfo) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
+	now := time.Now()
+	if old := s.codes[task]; needsSnapshot(s.history[task], old, code, now) {
+		s.history[task] = addCodeVersion(s.history[task], CodeVersion{When: now, Code: old.Code, Lang: old.Lang})
+	}
+
 	s.codes[task] = code
+	s.persist()
+}
+
+// AddSubmittedCode keeps the code of a submission in the history of its task.
+func (s *Session) AddSubmittedCode(task string, code CodeInfo, id uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.history[task] = addCodeVersion(s.history[task], CodeVersion{
+		When:       time.Now(),
+		Code:       code.Code,
+		Lang:       code.Lang,
+		Submission: id,
+	})
+	s.persist()
+}
+
+// GetCodeHistory returns the saved versions of the code of a task, from the
+// oldest one.
+func (s *Session) GetCodeHistory(task string) []CodeVersion {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return append([]CodeVersion(nil), s.history[task]...)
+}
+
+// GetCodeVersion returns a single saved version of the code of a task.
+func (s *Session) GetCodeVersion(task string, id int) (CodeVersion, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, v := range s.history[task] {
+		if v.ID == id {
+			return v, true
+		}
+	}
+	return CodeVersion{}, false
+}
+
+// RestoreCode replaces the code of a task by a saved version of it, saving the
+// replaced code in the history first.
+func (s *Session) RestoreCode(task string, id int) (CodeInfo, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var code CodeInfo
+	found := false
+	for _, v := range s.history[task] {
+		if v.ID == id {
+			code = CodeInfo{Code: v.Code, Lang: v.Lang}
+			found = true
+			break
+		}
+	}
+	if !found {
+		return code, false
+	}
+
+	if old := s.codes[task]; len(old.Code) > 0 && old.Code != code.Code {
+		s.history[task] = addCodeVersion(s.history[task], CodeVersion{When: time.Now(), Code: old.Code, Lang: old.Lang})
+	}
+
+	s.codes[task] = code
+	s.persist()
+	return code, true
+}
+
+// persist schedules the session to be stored, if the session manager is
+// persistent. It should be called with the session lock held.
+func (s *Session) persist() {
+	if s.store != nil {
+		s.store.markDirty(s)
+	}
+}
+
+// stored returns what is stored of the session. It should be called with the
+// session lock held.
+func (s *Session) stored() storedSession {
+	st := storedSession{
+		SID:          s.sid,
+		User:         s.user,
+		Password:     s.password,
+		TaskVerdicts: s.taskVerdicts,
+		TestVerdicts: s.testVerdicts,
+		Codes:        s.codes,
+		History:      s.history,
+		FirstLogin:   s.firstLogin,
+
+		Clarifications: s.clarifications,
+		LastEventID:    s.lastEventID,
+		Pending:        s.pending,
+	}
+
+	if s.database != nil {
+		st.DatabasePath = s.database.path
+	}
+
+	return st
 }
 
 func (s *Session) GetCode(task string) CodeInfo {
@@ -301,3 +998,15 @@ func (s *Session) GetCode(task string) CodeInfo {
 
 	return s.codes[task]
 }
+
+// GetCodes returns the code being edited of every task.
+func (s *Session) GetCodes() map[string]CodeInfo {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	codes := make(map[string]CodeInfo, len(s.codes))
+	for task, code := range s.codes {
+		codes[task] = code
+	}
+	return codes
+}
@@ -5,6 +5,10 @@ const Result = {
   Failed: 3,
   Correct: 4,
   Wrong: 5,
+  Partial: 6,
+  WallTimeout: 7,
+  OutputLimit: 8,
+  MemoryLimit: 9,
 };
 
 const ResultComp = {
@@ -188,9 +192,7 @@ function setupCodeEditor() {
           $('#loading-test').show()
           $('#test-info').hide()
 
-          getResult('/gettest', {
-            id: data.ID,
-          }, function(result) {
+          watchTest(data.ID, function(result) {
             $('#loading-test').hide();
             $('#test-info').show()
             formatTest(result);
@@ -229,10 +231,357 @@ function setupCodeEditor() {
       lang: $('select#lang').val(),
     }, null, "json");
   }, 250));
+
+  setupCodeHistory(editor);
+  setupStressTest(editor);
+};
+
+// setupCodeHistory lists the saved versions of the code, allowing them to be
+// compared with the code in the editor or put back into it.
+function setupCodeHistory(editor) {
+  var panel = $('div#code-history');
+  var tbody = $('#code-history-table').children('tbody');
+  var diff = $('pre#code-diff');
+
+  var load = function() {
+    $.get('/getcodehistory', {
+      task: getTaskName(),
+    }, function(data) {
+      tbody.html('');
+      diff.hide();
+
+      // newest versions first
+      $.each(data.reverse(), function(index, version) {
+        var row = $('<tr></tr>');
+        var versionTd = $('<td></td>');
+        var actionTd = $('<td></td>');
+        row.append($('<td></td>').text(moment(version.When).format('L LTS')))
+          .append(versionTd)
+          .append($('<td></td>').text(version.Lines))
+          .append(actionTd);
+        tbody.append(row);
+
+        if (version.Submission > 0) {
+          t("submission", function(str) {
+            versionTd.text(str + " #" + version.Submission);
+          });
+        } else {
+          t("autosave", function(str) {
+            versionTd.text(str);
+          });
+        }
+
+        t("diff", function(str) {
+          var button = $('<button class="small-button"></button>').text(str);
+          button.click(function() {
+            showCodeDiff(diff, {
+              task: getTaskName(),
+              from: version.ID,
+            });
+          });
+          actionTd.prepend(button);
+        });
+
+        t("restore", function(str) {
+          var button = $('<button class="small-button"></button>').text(str);
+          button.click(function() {
+            restoreCode(editor, version.ID, load);
+          });
+          actionTd.append(button);
+        });
+      });
+    }, "json");
+  };
+
+  $('button#show-code-history').click(function() {
+    if (panel.is(':visible')) {
+      panel.hide();
+    } else {
+      panel.show();
+      load();
+    }
+  });
+};
+
+function showCodeDiff(tag, params) {
+  $.get('/getcodediff', params, function(data) {
+    tag.html('');
+    $.each(data.Lines, function(index, line) {
+      var span = $('<span></span>').text(line.Kind + " " + line.Text + "\n");
+      if (line.Kind == "+") {
+        span.addClass("diff-insert");
+      } else if (line.Kind == "-") {
+        span.addClass("diff-delete");
+      }
+      tag.append(span);
+    });
+    tag.show();
+  }, "json").fail(function(data) {
+    data = JSON.parse(data.responseText)
+    t("error", function(str) {
+      toastr.error(str + ": " + data.Error);
+    });
+  });
+};
+
+function restoreCode(editor, version, callback) {
+  $.ajax({
+    url: '/restorecode',
+    type: 'POST',
+    data: {
+      task: getTaskName(),
+      version: version,
+    },
+    success: function(data) {
+      data = JSON.parse(data)
+
+      var langSelect = $('select#lang');
+      langSelect.val(data.Code.Lang);
+      editor.setOption("mode", langSelect[0].options[langSelect[0].selectedIndex].getAttribute('mime'))
+      editor.setValue(data.Code.Code);
+
+      t("code_restored", function(str) {
+        toastr.success(str);
+      });
+
+      if (callback) callback();
+    },
+    error: function(data) {
+      data = JSON.parse(data.responseText)
+      t("error", function(str) {
+        toastr.error(str + ": " + data.Error);
+      });
+    },
+  });
+};
+
+// setupStressTest runs the code in the editor against a brute force solution
+// over generated inputs, keeping the generator and the brute force of each
+// task in the browser.
+function setupStressTest(editor) {
+  var panel = $('div#stress-test');
+  var form = $('form#stress-form');
+  var mismatch = $('div#stress-mismatch');
+  var resultSpan = $('span#stress-result');
+
+  $.each(['generator', 'generatorlang', 'brute', 'brutelang'], function(index, name) {
+    var key = 'stress-' + getTaskName() + '-' + name;
+    var field = form.find('#' + name);
+    if (localStorage.getItem(key) != null) {
+      field.val(localStorage.getItem(key));
+    }
+    field.change(function() {
+      localStorage.setItem(key, field.val());
+    });
+  });
+
+  $('button#show-stress-test').click(function() {
+    panel.toggle();
+  });
+
+  form.submit(function(e) {
+    e.preventDefault();
+
+    var data = form.serializeArray();
+    data.push({name: 'code', value: editor.getValue()});
+    data.push({name: 'lang', value: $('select#lang').val()});
+
+    $.ajax({
+      url: '/stress/' + getTaskName(),
+      type: 'POST',
+      data: $.param(data),
+      success: function(data) {
+        data = JSON.parse(data)
+
+        resultSpan.text('');
+        mismatch.hide();
+        $('#loading-stress').show();
+
+        watchStress(data.ID, function(result) {
+          $('#loading-stress').hide();
+          formatStress(result, resultSpan, mismatch);
+        });
+      },
+      error: function(data) {
+        data = JSON.parse(data.responseText)
+        t("error", function(str) {
+          toastr.error(str + ": " + data.Error);
+        });
+      },
+    });
+  });
+};
+
+function watchStress(id, callback) {
+  if (events != null) {
+    stressCallbacks[id] = callback;
+    $.get('/getstress', {
+      id: id,
+    }, function(data) {
+      if (data.length > 0 && stressCallbacks[id] == callback) {
+        delete stressCallbacks[id];
+        callback(data[0]);
+      }
+    }, "json");
+    return;
+  }
+
+  getResult('/getstress', {
+    id: id,
+  }, callback);
+};
+
+function formatStressSeed(data, callback) {
+  t("seed", function(seed) {
+    t("size", function(size) {
+      callback("(" + seed + " " + data.Seed + ", " + size + " " + data.Size + ")");
+    });
+  });
+};
+
+function formatStress(data, tag, mismatch) {
+  if (data.Error) {
+    t("error", function(str) {
+      tag.text(str + ": " + data.Extra);
+    });
+    return;
+  }
+
+  if (data.Program && !data.Mismatch) {
+    var key = data.Compilation != ResultComp.Success ? formatCompilationKey(data.Compilation) : formatResultKey(data.Result);
+    t("stress_" + data.Program, function(program) {
+      t(key, function(result) {
+        if (data.Compilation != ResultComp.Success) {
+          tag.text(program + ": " + result);
+          return;
+        }
+        formatStressSeed(data, function(seed) {
+          tag.text(program + " " + seed + ": " + result);
+        });
+      });
+    });
+
+    if (data.Input) {
+      mismatch.find('#stress-input').text(data.Input);
+      mismatch.find('#stress-expected').text('');
+      mismatch.find('#stress-output').text('');
+      mismatch.show();
+    }
+    return;
+  }
+
+  if (data.Mismatch) {
+    t("stress_mismatch", function(str) {
+      t(formatResultKey(data.Result), function(result) {
+        formatStressSeed(data, function(seed) {
+          tag.text(str + " " + seed + ": " + result);
+        });
+      });
+    });
+
+    mismatch.find('#stress-input').text(data.Input);
+    mismatch.find('#stress-expected').text(data.Expected);
+    mismatch.find('#stress-output').text(data.Output);
+    mismatch.show();
+    return;
+  }
+
+  t("stress_passed", function(str) {
+    tag.text(str + ": " + data.Runs);
+  });
+};
+
+function setupOutputsForm() {
+  var form = $('form#outputs-form');
+  form.submit(function(e) {
+    e.preventDefault();
+
+    $.ajax({
+      url: '/submit/' + getTaskName(),
+      type: 'POST',
+      data: new FormData(form[0]),
+      processData: false,
+      contentType: false,
+      success: function(data) {
+        data = JSON.parse(data)
+
+        t("submission_sent", function(str) {
+          toastr.success(str);
+        });
+
+        appendSubmission(data.ID);
+      },
+      error: function(data) {
+        data = JSON.parse(data.responseText)
+        t("error", function(str) {
+          toastr.error(str + ": " + data.Error);
+        });
+      },
+    });
+  });
 };
 
 function formatTime(data) {
-  return moment(data.When).format('LTS');
+  var time = moment(data.When).format('LTS');
+  if (data.Elapsed > 0) {
+    time += " (" + formatClock(data.Elapsed) + ")";
+  }
+  return time;
+};
+
+// formatClock formats a duration in nanoseconds as hours, minutes and
+// seconds.
+function formatClock(duration) {
+  var seconds = Math.floor(duration / 10 ** 9);
+  var pad = function(n) {
+    return (n < 10 ? "0" : "") + n;
+  };
+  return Math.floor(seconds / 3600) + ":" + pad(Math.floor(seconds / 60) % 60) + ":" + pad(seconds % 60);
+};
+
+// setupTimer shows the time left in the contest, counting down locally and
+// asking the server again whenever the contest starts or ends.
+function setupTimer() {
+  var tag = $('#contest-timer');
+
+  $.get('/gettimer', {}, function(data) {
+    if (data.Started && !data.Limited && !data.Frozen) return;
+
+    var deadline = Date.now() + data.Remaining / 10 ** 6;
+    var key = "time_remaining";
+    if (!data.Started) {
+      key = "contest_starts_in";
+    } else if (data.Ended) {
+      key = "contest_ended";
+    }
+
+    var counting = !data.Started || (data.Limited && !data.Ended);
+
+    t(key, function(str) {
+      tag.text(str).show();
+      if (!counting) return;
+
+      var update = function() {
+        var remaining = deadline - Date.now();
+        if (remaining <= 0) {
+          clearInterval(interval);
+          setupTimer();
+          return;
+        }
+
+        tag.text(str + " " + formatClock(remaining * 10 ** 6));
+      };
+
+      var interval = setInterval(update, 1000);
+      update();
+    });
+
+    if (data.Frozen) {
+      t("results_frozen", function(str) {
+        $('#contest-timer-frozen').text(str).show();
+      });
+    }
+  }, "json");
 };
 
 function formatDuration(duration) {
@@ -253,6 +602,14 @@ function formatBatchesDuration(data) {
   return formatDuration(duration);
 };
 
+function formatScore(score) {
+  return +score.toFixed(2);
+};
+
+function formatTaskScore(score, maxScore) {
+  return formatScore(score) + " / " + formatScore(maxScore);
+};
+
 function formatMemory(kb) {
   if (kb == 0)
     return "-"
@@ -298,6 +655,14 @@ function formatResultKey(data) {
     return "result_failed"
   } else if (data == Result.Wrong) {
     return "result_wrong"
+  } else if (data == Result.Partial) {
+    return "result_partial"
+  } else if (data == Result.WallTimeout) {
+    return "result_wall_timeout"
+  } else if (data == Result.OutputLimit) {
+    return "result_output_limit"
+  } else if (data == Result.MemoryLimit) {
+    return "result_memory_limit"
   } else {
     return "result_correct"
   }
@@ -314,6 +679,10 @@ function formatResult(data, tag) {
 
   if (data.Error) {
     key = "error"
+  } else if (data.Cancelled) {
+    key = "result_cancelled"
+  } else if (data.Frozen) {
+    key = "result_frozen"
   } else {
     key = formatCompilationKey(data.Compilation)
 
@@ -393,7 +762,7 @@ function formatResultExtra(result, batch, score, time, memory, extra, tag) {
 
   if (score != -1) {
     t('score', function(scorestr) {
-      scoreDiv.text(scorestr + ': ' + score);
+      scoreDiv.text(scorestr + ': ' + formatScore(score));
     });
     tag.append(scoreDiv);
   }
@@ -413,13 +782,86 @@ function formatResultExtra(result, batch, score, time, memory, extra, tag) {
   tag.append(extraDiv);
 };
 
+function formatTestsTable(tests, testNumbers, tag) {
+  var table = $('<table class="u-full-width tests-table"></table>');
+  var head = $('<tr></tr>');
+  var body = $('<tbody></tbody>');
+  table.append($('<thead></thead>').append(head)).append(body);
+
+  $.each(["test", "result", "time", "wall_time", "memory", "details"], function(index, key) {
+    var th = $('<th></th>');
+    head.append(th);
+    t(key, function(str) {
+      th.text(str);
+    });
+  });
+
+  $.each(testNumbers, function(index, n) {
+    var test = tests.find(function(test) {
+      return test.N == n;
+    });
+    if (test == undefined) return;
+
+    var td = '<td></td>';
+    var numberTd = $(td),
+      resultTd = $(td),
+      timeTd = $(td),
+      wallTimeTd = $(td),
+      memoryTd = $(td),
+      extraTd = $(td);
+    body.append($('<tr></tr>').append(numberTd).append(resultTd).append(timeTd)
+      .append(wallTimeTd).append(memoryTd).append(extraTd));
+
+    numberTd.text(test.N);
+
+    var key = formatResultKey(test.Result);
+    t(key, function(str) {
+      resultTd.text(str);
+      resultTd.css("color", (key == "result_correct") ? "green" : "red");
+    });
+
+    timeTd.text(test.Time != undefined ? formatDuration(test.Time) : "-");
+    wallTimeTd.text(test.WallTime != undefined ? formatDuration(test.WallTime) : "-");
+    memoryTd.text(test.Memory != undefined ? formatMemory(test.Memory) : "-");
+    extraTd.text(test.Extra != undefined ? test.Extra : "");
+  });
+
+  tag.append(table);
+};
+
+function formatBatchTests(tests, testNumbers, tag) {
+  if (tests == null || testNumbers == null || testNumbers.length == 0) {
+    return
+  }
+
+  var testsDiv = $('<div></div>');
+  var toggle = $('<button class="small-button"></button>');
+  tag.append(toggle).append(testsDiv);
+
+  t("show_tests", function(str) {
+    toggle.text(str);
+  });
+
+  testsDiv.hide();
+  toggle.click(function() {
+    if (testsDiv.is(':empty')) {
+      formatTestsTable(tests, testNumbers, testsDiv);
+    }
+    testsDiv.toggle();
+  });
+};
+
 function formatBatchesResult(data, tag) {
-  if (data == null) {
+  if (data.Batches == null) {
     return
   }
 
-  for (var i = 0; i < data.length; i++) {
-    formatResultExtra(data[i].Result, i, data[i].Score, data[i].Time, data[i].Memory, data[i].Extra, tag);
+  for (var i = 0; i < data.Batches.length; i++) {
+    var batch = data.Batches[i];
+    var batchDiv = $('<div></div>');
+    formatResultExtra(batch.Result, i, batch.Score, batch.Time, batch.Memory, batch.Extra, batchDiv);
+    formatBatchTests(data.Tests, batch.Tests, batchDiv);
+    tag.append(batchDiv);
   }
 };
 
@@ -458,6 +900,20 @@ function formatExtra(data, tag) {
     return
   }
 
+  if (data.Cancelled) {
+    t("explanation_result_cancelled", function(explanation) {
+      tag.text(explanation);
+    });
+    return
+  }
+
+  if (data.Frozen) {
+    t("explanation_result_frozen", function(explanation) {
+      tag.text(explanation);
+    });
+    return
+  }
+
   compDiv = $('<div></div>');
   formatCompilationExtra(data, compDiv);
   tag.append(compDiv);
@@ -465,7 +921,7 @@ function formatExtra(data, tag) {
   if (data.Compilation == ResultComp.Success) {
     if (data.Batches != undefined) {
       batchesDiv = $('<div></div>');
-      formatBatchesResult(data.Batches, batchesDiv);
+      formatBatchesResult(data, batchesDiv);
       tag.append(batchesDiv);
     }
 
@@ -486,7 +942,7 @@ function formatBatchesScore(data) {
   for (var i = 0; i < data.Batches.length; i++) {
     score += data.Batches[i].Score;
   }
-  return score;
+  return formatScore(score);
 };
 
 var testInfoExtra = document.createElement('div');
@@ -523,9 +979,20 @@ function formatSubmission(data, row) {
     scoreTd = $(td),
     durationTd = $(td),
     memoryTd = $(td),
-    langTd = $(td);
+    langTd = $(td),
+    actionTd = $(td);
   row.append(timeTd).append(resultTd).append(scoreTd)
-    .append(durationTd).append(memoryTd).append(langTd);
+    .append(durationTd).append(memoryTd).append(langTd).append(actionTd);
+
+  t("rejudge", function(str) {
+    var button = $('<button class="small-button"></button>').text(str);
+    button.click(function() {
+      submissionAction(data.ID, 'rejudge', function() {
+        watchSubmission(data.ID, row);
+      });
+    });
+    actionTd.append(button);
+  });
 
   timeTd.html(formatTime(data));
   formatResult(data, resultTd);
@@ -601,13 +1068,284 @@ function appendSubmission(id) {
   var row = $("<tr></tr>");
   tbody.append(row);
 
-  row.html('<td colspan="6"><div class="loading"></div></td>');
+  watchSubmission(id, row);
+};
+
+function watchSubmission(id, row) {
+  row.html('<td colspan="6"><div class="loading"></div><span class="progress"></span></td>');
+
+  var actionTd = $('<td></td>');
+  row.append(actionTd);
+
+  t("cancel", function(str) {
+    var button = $('<button class="small-button"></button>').text(str);
+    button.click(function() {
+      submissionAction(id, 'cancel');
+    });
+    actionTd.append(button);
+  });
+
+  var finish = function(result) {
+    row.html('');
+    formatSubmission(result, row);
+  };
+
+  if (events != null) {
+    // the verdict might have arrived before the row was watched
+    submissionRows[id] = {
+      row: row,
+      finish: finish
+    };
+    $.get('/getsubmission', {
+      id: id,
+    }, function(data) {
+      if (data.length > 0 && submissionRows[id] != undefined && submissionRows[id].row == row) {
+        delete submissionRows[id];
+        finish(data[0]);
+      }
+    }, "json");
+    return;
+  }
+
+  var done = false;
+  watchProgress(id, row.find('.progress'), function() {
+    return done;
+  });
 
   getResult('/getsubmission', {
     id: id,
   }, function(result) {
-    row.html('');
-    formatSubmission(result, row);
+    done = true;
+    finish(result);
+  });
+};
+
+function watchTest(id, callback) {
+  if (events != null) {
+    testCallbacks[id] = callback;
+    $.get('/gettest', {
+      id: id,
+    }, function(data) {
+      if (data.length > 0 && testCallbacks[id] == callback) {
+        delete testCallbacks[id];
+        callback(data[0]);
+      }
+    }, "json");
+    return;
+  }
+
+  getResult('/gettest', {
+    id: id,
+  }, callback);
+};
+
+var events = null;
+var submissionRows = {};
+var testCallbacks = {};
+var stressCallbacks = {};
+var clarificationsChanged = null;
+
+// setupEvents listens to the verdicts and progress updates pushed by the
+// server, falling back to polling on browsers without EventSource.
+function setupEvents() {
+  if (typeof(EventSource) == "undefined" || events != null) return;
+
+  events = new EventSource('/events');
+
+  events.addEventListener('submission', function(e) {
+    var data = JSON.parse(e.data);
+    var watched = submissionRows[data.ID];
+    if (watched == undefined) return;
+
+    delete submissionRows[data.ID];
+    watched.finish(data);
+  });
+
+  events.addEventListener('progress', function(e) {
+    var data = JSON.parse(e.data);
+    var watched = submissionRows[data.ID];
+    if (watched == undefined) return;
+
+    formatProgress(data, watched.row.find('.progress'));
+  });
+
+  events.addEventListener('test', function(e) {
+    var data = JSON.parse(e.data);
+    var callback = testCallbacks[data.ID];
+    if (callback == undefined) return;
+
+    delete testCallbacks[data.ID];
+    callback(data);
+  });
+
+  events.addEventListener('stress', function(e) {
+    var data = JSON.parse(e.data);
+    var callback = stressCallbacks[data.ID];
+    if (callback == undefined) return;
+
+    delete stressCallbacks[data.ID];
+    callback(data);
+  });
+
+  events.addEventListener('clarification', function(e) {
+    var data = JSON.parse(e.data);
+    t("clarification_answered", function(str) {
+      toastr.info(str + ": " + data.Question);
+    });
+
+    if (clarificationsChanged) clarificationsChanged();
+  });
+
+  events.addEventListener('announcement', function(e) {
+    var data = JSON.parse(e.data);
+    t("announcement", function(str) {
+      toastr.info(data.Text, str);
+    });
+    showAnnouncement(data);
+  });
+};
+
+function dismissedAnnouncements() {
+  try {
+    return JSON.parse(localStorage.getItem('dismissed-announcements')) || [];
+  } catch (e) {
+    return [];
+  }
+};
+
+function showAnnouncement(data) {
+  if (dismissedAnnouncements().indexOf(data.ID) >= 0) return;
+
+  var box = $('<div class="announcement"></div>');
+  var dismiss = $('<button class="small-button" style="float: right">x</button>');
+  dismiss.click(function() {
+    var dismissed = dismissedAnnouncements();
+    dismissed.push(data.ID);
+    localStorage.setItem('dismissed-announcements', JSON.stringify(dismissed));
+    box.remove();
+  });
+
+  box.append(dismiss)
+    .append($('<small></small>').text(moment(data.When).format('L LTS') + (data.TaskName ? " - " + data.TaskName : "")));
+  if (data.Question) {
+    box.append($('<p class="announcement-question"></p>').text(data.Question));
+  }
+  box.append($('<p></p>').text(data.Text));
+
+  $('#announcements').prepend(box);
+};
+
+// setupAnnouncements shows the announcements not dismissed yet, and the new
+// ones as soon as they are sent.
+function setupAnnouncements() {
+  $.get('/getclarifications', {}, function(data) {
+    $.each(data.Announcements, function(index, announcement) {
+      showAnnouncement(announcement);
+    });
+  }, "json");
+
+  setupEvents();
+};
+
+// setupClarifications lists the questions asked about the task and sends new
+// ones to the contest admin.
+function setupClarifications() {
+  var tbody = $('#clarifications-table').children('tbody');
+
+  var load = function() {
+    $.get('/getclarifications', {}, function(data) {
+      tbody.html('');
+      $.each(data.Clarifications.reverse(), function(index, clarification) {
+        if (clarification.TaskName != getTaskName()) return;
+
+        var row = $("<tr></tr>");
+        var answerTd = $("<td></td>").text(clarification.Answer);
+        if (!clarification.Answer) {
+          t("not_answered", function(str) {
+            answerTd.text(str);
+          });
+        }
+        row.append($("<td></td>").text(moment(clarification.When).format('L LTS')))
+          .append($("<td></td>").text(clarification.Question))
+          .append(answerTd);
+        tbody.append(row);
+      });
+    }, "json");
+  };
+
+  clarificationsChanged = load;
+  load();
+
+  var form = $('form#clarification-form');
+  form.submit(function(e) {
+    e.preventDefault();
+
+    $.ajax({
+      url: '/clarification',
+      type: 'POST',
+      data: {
+        task: getTaskName(),
+        question: form.find('#question').val(),
+      },
+      success: function(data) {
+        form.find('#question').val('');
+        t("clarification_sent", function(str) {
+          toastr.success(str);
+        });
+        load();
+      },
+      error: function(data) {
+        data = JSON.parse(data.responseText)
+        t("error", function(str) {
+          toastr.error(str + ": " + data.Error);
+        });
+      },
+    });
+  });
+};
+
+function watchProgress(id, tag, done) {
+  if (done()) return;
+
+  $.get('/getprogress', {
+    id: id,
+  }, function(data) {
+    if (done()) return;
+
+    if (data.length > 0) {
+      formatProgress(data[0], tag);
+    }
+
+    setTimeout(function() {
+      watchProgress(id, tag, done);
+    }, 1000);
+  }, "json");
+};
+
+function formatProgress(data, tag) {
+  t("progress_" + data.State, function(str) {
+    if (data.State == "queued" && data.Position > 0) {
+      str += " (" + data.Position + ")";
+    } else if (data.Tests > 0) {
+      str += ": " + data.Done + "/" + data.Tests;
+    }
+    tag.text(str);
+  });
+};
+
+function submissionAction(id, action, callback) {
+  $.ajax({
+    url: '/submission/' + id + '/' + action,
+    type: 'POST',
+    success: function(data) {
+      if (callback) callback();
+    },
+    error: function(data) {
+      data = JSON.parse(data.responseText)
+      t("error", function(str) {
+        toastr.error(str + ": " + data.Error);
+      });
+    },
   });
 };
 
@@ -623,6 +1361,14 @@ function setupSubmissions() {
       tbody.append(row);
       formatSubmission(submission, row);
     });
+
+    $.get('/getprogress', {
+      task: getTaskName()
+    }, function(data) {
+      $.each(data, function(index, progress) {
+        appendSubmission(progress.ID);
+      });
+    }, "json");
   }, "json");
 };
 
@@ -630,17 +1376,22 @@ function setupOverviewSubmissions() {
   table = $('#submissions-table')
   tbody = table.children('tbody')
 
-  $.get('/gettasks', {}, function(data) {
-    $.each(data, function(index, task) {
+  $.get('/getscore', {}, function(data) {
+    $.each(data.Tasks, function(index, task) {
       var row = $("<tr></tr>");
       tbody.append(row);
-      row.append("<td>" + task.Title + "</td>");
+      row.append($("<td></td>").text(task.Title));
+
+      var bestTd = $("<td></td>").text(formatTaskScore(task.Score, task.MaxScore));
       $.get('/getsubmission', {
         task: task.Name,
       }, function(submissions) {
         formatOverviewSubmission(submissions, row);
+        row.append(bestTd);
       }, "json");
     });
+
+    $('#total-score').text(formatTaskScore(data.Total, data.MaxTotal));
   }, "json");
 };
 
@@ -648,11 +1399,318 @@ function setupTaskPage() {
   setupKaTeX();
   setupClickToCopy();
   setupTestCaseCopy();
-  setupCodeEditor();
-  setupTestTippy();
+  if ($('form#outputs-form').length > 0) {
+    setupOutputsForm();
+  } else {
+    setupCodeEditor();
+    setupTestTippy();
+  }
+  setupEvents();
   setupSubmissions();
+  setupClarifications();
 };
 
 function setupOverviewPage() {
   setupOverviewSubmissions();
 };
+
+function setupScoreboard() {
+  var tbody = $('#scoreboard-table').children('tbody');
+
+  $.get('/getscoreboard', {}, function(data) {
+    tbody.html('');
+
+    $.each(data.Entries, function(index, entry) {
+      var row = $("<tr></tr>");
+      row.append($("<td></td>").text(entry.Rank));
+      row.append($("<td></td>").text(entry.Name));
+      $.each(entry.Tasks, function(index, task) {
+        row.append($("<td></td>").text(task.Submissions > 0 ? formatScore(task.Score) : "-"));
+      });
+      row.append($("<th></th>").text(formatScore(entry.Total)));
+      tbody.append(row);
+    });
+  }, "json");
+};
+
+function setupScoreboardPage() {
+  setupScoreboard();
+  setInterval(setupScoreboard, 30000);
+};
+
+function adminAction(id, action, callback) {
+  $.ajax({
+    url: '/admin/submission/' + id + '/' + action,
+    type: 'POST',
+    success: function(data) {
+      if (callback) callback();
+    },
+    error: function(data) {
+      data = JSON.parse(data.responseText)
+      t("error", function(str) {
+        toastr.error(str + ": " + data.Error);
+      });
+    },
+  });
+};
+
+function adminButton(key, callback) {
+  var button = $('<button class="small-button"></button>');
+  t(key, function(str) {
+    button.text(str);
+  });
+  button.click(callback);
+  return button;
+};
+
+function formatSessionName(data) {
+  return data.User ? data.User : data.SID.substr(0, 8);
+};
+
+// setupAdminState shows the judge workers, the judge queue and the sessions,
+// refreshing them every few seconds.
+function setupAdminState() {
+  $.get('/admin/getstate', {}, function(data) {
+    var workers = $('#workers-table').children('tbody').html('');
+    $.each(data.Workers, function(index, worker) {
+      var row = $("<tr></tr>");
+      var stateTd = $("<td></td>");
+      t("worker_" + worker.State, function(str) {
+        stateTd.text(worker.ID > 0 ? str + " #" + worker.ID : str);
+      });
+      row.append($("<td></td>").text(worker.Worker))
+        .append(stateTd)
+        .append($("<td></td>").text(worker.Task))
+        .append($("<td></td>").text(moment(worker.Since).format('LTS')));
+      workers.append(row);
+    });
+
+    var queue = $('#queue-table').children('tbody').html('');
+    $.each(data.Queue, function(index, item) {
+      var row = $("<tr></tr>");
+      var actionTd = $("<td></td>");
+      if (!item.CustomTest && !item.StressTest) {
+        actionTd.append(adminButton("cancel", function() {
+          adminAction(item.ID, 'cancel', setupAdminState);
+        }));
+      }
+      row.append($("<td></td>").text(item.Position))
+        .append($("<td></td>").text(item.CustomTest || item.StressTest ? "-" : item.ID))
+        .append($("<td></td>").text(item.SID.substr(0, 8)))
+        .append($("<td></td>").text(item.Task))
+        .append(actionTd);
+      queue.append(row);
+    });
+
+    var sessions = $('#sessions-table').children('tbody').html('');
+    $.each(data.Sessions, function(index, session) {
+      var row = $("<tr></tr>");
+      row.append($("<td></td>").text(formatSessionName(session)))
+        .append($("<td></td>").text(session.LoggedIn ? session.Contest : "-"))
+        .append($("<td></td>").text(session.Submissions))
+        .append($("<td></td>").text(session.Tests));
+      sessions.append(row);
+    });
+
+    if (data.Multi) {
+      $('#reload-contest').show();
+    }
+  }, "json");
+};
+
+function setupAdminSubmissions() {
+  $.get('/admin/getsubmissions', {}, function(data) {
+    var tbody = $('#admin-submissions-table').children('tbody').html('');
+    $.each(data, function(index, submission) {
+      var row = $("<tr></tr>");
+      var resultTd = $("<td></td>");
+      var langTd = $("<td></td>").text(submission.LangName);
+      var actionTd = $("<td></td>");
+
+      row.append($("<td></td>").text(submission.ID))
+        .append($("<td></td>").text(submission.User ? submission.User : submission.SID.substr(0, 8)))
+        .append($("<td></td>").text(submission.TaskName))
+        .append($("<td></td>").html(formatTime(submission)))
+        .append(resultTd)
+        .append($("<td></td>").html(formatBatchesScore(submission)))
+        .append(langTd)
+        .append(actionTd);
+      tbody.append(row);
+
+      formatResult(submission, resultTd);
+
+      var extra = $(document.createElement('div'));
+      tippy.one(resultTd[0], {
+        html: extra[0],
+        theme: 'light',
+        arrow: true,
+        distance: 0,
+        performance: true,
+        interactive: true,
+      });
+      formatExtra(submission, extra);
+
+      if (submission.Code) {
+        langTd.css("text-decoration", "underline");
+        var codePre = $('<pre class="cm-s-default"></pre>');
+        codePre.css("text-align", "left");
+        CodeMirror.runMode(submission.Code, submission.LangMime, codePre[0]);
+        tippy.one(langTd[0], {
+          html: codePre[0],
+          theme: 'light',
+          arrow: true,
+          distance: 0,
+          performance: true,
+          interactive: true,
+        });
+      }
+
+      actionTd.append(adminButton("rejudge", function() {
+        adminAction(submission.ID, 'rejudge', function() {
+          setTimeout(setupAdminSubmissions, 1000);
+        });
+      }));
+    });
+  }, "json");
+};
+
+function setupReloadForm() {
+  var form = $('form#reload-form');
+  form.submit(function(e) {
+    e.preventDefault();
+
+    $.ajax({
+      url: '/admin/reload',
+      type: 'POST',
+      data: new FormData(form[0]),
+      processData: false,
+      contentType: false,
+      success: function(data) {
+        data = JSON.parse(data)
+        t("contest_reloaded", function(str) {
+          toastr.success(str + ": " + data.Title);
+        });
+        setupAdminState();
+      },
+      error: function(data) {
+        data = JSON.parse(data.responseText)
+        t("error", function(str) {
+          toastr.error(str + ": " + data.Error);
+        });
+      },
+    });
+  });
+};
+
+// setupAdminClarifications lists the questions of the contestants, with a
+// form to answer each of the unanswered ones.
+function setupAdminClarifications() {
+  $.get('/admin/getclarifications', {}, function(data) {
+    var tbody = $('#admin-clarifications-table').children('tbody');
+
+    // don't throw away an answer being written
+    if (tbody.find('textarea').filter(function() {
+        return $(this).val().length > 0;
+      }).length > 0) return;
+
+    tbody.html('');
+    $.each(data.Clarifications, function(index, clarification) {
+      var row = $("<tr></tr>");
+      var answerTd = $("<td></td>");
+
+      row.append($("<td></td>").text(moment(clarification.When).format('L LTS')))
+        .append($("<td></td>").text(formatSessionName(clarification)))
+        .append($("<td></td>").text(clarification.TaskName ? clarification.TaskName : "-"))
+        .append($("<td></td>").text(clarification.Question))
+        .append(answerTd);
+      tbody.append(row);
+
+      if (clarification.Answer) {
+        answerTd.text(clarification.Answer);
+        if (clarification.Broadcast) {
+          t("broadcast", function(str) {
+            answerTd.append($("<small></small>").text(" (" + str + ")"));
+          });
+        }
+        return;
+      }
+
+      var answer = $('<textarea class="u-full-width"></textarea>');
+      var broadcast = $('<input type="checkbox">');
+      var broadcastLabel = $('<span class="label-body"></span>');
+      t("broadcast", function(str) {
+        broadcastLabel.text(str);
+      });
+
+      answerTd.append(answer)
+        .append(broadcast)
+        .append(broadcastLabel)
+        .append(adminButton("answer", function() {
+          $.ajax({
+            url: '/admin/clarification/' + clarification.ID + '/answer',
+            type: 'POST',
+            data: {
+              answer: answer.val(),
+              broadcast: broadcast.is(':checked'),
+            },
+            success: function(data) {
+              answer.val('');
+              setupAdminClarifications();
+            },
+            error: function(data) {
+              data = JSON.parse(data.responseText)
+              t("error", function(str) {
+                toastr.error(str + ": " + data.Error);
+              });
+            },
+          });
+        }));
+    });
+
+    var announcements = $('#admin-announcements-table').children('tbody').html('');
+    $.each(data.Announcements.reverse(), function(index, announcement) {
+      var row = $("<tr></tr>");
+      row.append($("<td></td>").text(moment(announcement.When).format('L LTS')))
+        .append($("<td></td>").text(announcement.TaskName ? announcement.TaskName : "-"))
+        .append($("<td></td>").text(announcement.Text));
+      announcements.append(row);
+    });
+  }, "json");
+};
+
+function setupAnnounceForm() {
+  var form = $('form#announce-form');
+  form.submit(function(e) {
+    e.preventDefault();
+
+    $.ajax({
+      url: '/admin/announce',
+      type: 'POST',
+      data: form.serialize(),
+      success: function(data) {
+        form.find('#text').val('');
+        t("announcement_sent", function(str) {
+          toastr.success(str);
+        });
+        setupAdminClarifications();
+      },
+      error: function(data) {
+        data = JSON.parse(data.responseText)
+        t("error", function(str) {
+          toastr.error(str + ": " + data.Error);
+        });
+      },
+    });
+  });
+};
+
+function setupAdminPage() {
+  setupAdminState();
+  setupAdminSubmissions();
+  setupAdminClarifications();
+  setupReloadForm();
+  setupAnnounceForm();
+  setInterval(setupAdminState, 2000);
+  setInterval(setupAdminSubmissions, 10000);
+  setInterval(setupAdminClarifications, 10000);
+};
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	storeKeysFile          = "keys.json"
+	storeAnnouncementsFile = "announcements.json"
+	storeSessionsExt       = ".json"
+	storeTemporaryExt      = ".tmp"
+
+	// storeFlushInterval is how often the changed sessions are written, so a
+	// session changed many times in a row, as by the autosave of the code, is
+	// written only once
+	storeFlushInterval = time.Second
+)
+
+// sessionStore saves the sessions as JSON files inside a folder, so they can
+// be restored after the program restarts. The files hold the contest
+// passwords, so the folder is only readable by its owner. Sessions are marked
+// as changed while their lock is held, and written later in the background.
+type sessionStore struct {
+	folder string
+
+	// dirty holds the sessions changed since they were last written, while
+	// writeLock keeps the writes and removals of their files in order
+	dirty     map[string]*Session
+	lock      sync.Mutex
+	writeLock sync.Mutex
+
+	stopChannel chan struct{}
+	doneChannel chan struct{}
+}
+
+// storedKeys holds the session cookie parameters, which must survive restarts
+// for the restored sessions to be reachable.
+type storedKeys struct {
+	CookieName string
+	HashKey    []byte
+	BlockKey   []byte
+}
+
+// storedSession holds everything needed to restore a single session.
+type storedSession struct {
+	SID            string
+	User           string
+	Password       []byte
+	DatabasePath   string
+	TaskVerdicts   []TaskVerdict
+	TestVerdicts   []CustomTestVerdict
+	Codes          map[string]CodeInfo
+	History        map[string][]CodeVersion
+	FirstLogin     time.Time
+	Clarifications []Clarification
+	LastEventID    uint64
+	Pending        []PendingSubmission
+}
+
+func newSessionStore(folder string) (*sessionStore, error) {
+	if err := os.MkdirAll(folder, 0700); err != nil {
+		return nil, err
+	}
+
+	return &sessionStore{folder: folder, dirty: make(map[string]*Session)}, nil
+}
+
+// start begins writing the changed sessions in the background.
+func (st *sessionStore) start() {
+	if st.stopChannel != nil {
+		return
+	}
+
+	st.stopChannel = make(chan struct{})
+	st.doneChannel = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(storeFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				st.flush()
+			case <-st.stopChannel:
+				st.flush()
+				close(st.doneChannel)
+				return
+			}
+		}
+	}()
+}
+
+// stop writes the sessions changed since the last flush and stops writing
+// them in the background.
+func (st *sessionStore) stop() {
+	if st.stopChannel == nil {
+		return
+	}
+
+	close(st.stopChannel)
+	<-st.doneChannel
+	st.stopChannel = nil
+}
+
+// markDirty schedules a session to be written. It should be called with the
+// session lock held.
+func (st *sessionStore) markDirty(s *Session) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	st.dirty[s.sid] = s
+}
+
+// flush writes the sessions changed since the last flush. Each one is
+// encoded while its lock is held, but the file is written after releasing it.
+func (st *sessionStore) flush() {
+	st.lock.Lock()
+	dirty := st.dirty
+	st.dirty = make(map[string]*Session)
+	st.lock.Unlock()
+
+	for _, s := range dirty {
+		st.writeLock.Lock()
+
+		s.lock.Lock()
+		if s.removed {
+			s.lock.Unlock()
+			st.writeLock.Unlock()
+			continue
+		}
+		data, err := json.Marshal(s.stored())
+		s.lock.Unlock()
+
+		if err == nil {
+			err = st.writeData(s.sid+storeSessionsExt, data)
+		}
+		st.writeLock.Unlock()
+
+		if err != nil {
+			log.Print("Can't store session: ", err)
+		}
+	}
+}
+
+// keys returns the stored cookie parameters, storing the specified ones if
+// there are none yet.
+func (st *sessionStore) keys(defaults storedKeys) (storedKeys, error) {
+	var keys storedKeys
+
+	data, err := ioutil.ReadFile(filepath.Join(st.folder, storeKeysFile))
+	if os.IsNotExist(err) {
+		return defaults, st.write(storeKeysFile, defaults)
+	} else if err != nil {
+		return keys, err
+	}
+
+	err = json.Unmarshal(data, &keys)
+	return keys, err
+}
+
+// load reads all the stored sessions.
+func (st *sessionStore) load() ([]storedSession, error) {
+	files, err := ioutil.ReadDir(st.folder)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []storedSession
+	for _, file := range files {
+		if file.Name() == storeKeysFile || file.Name() == storeAnnouncementsFile ||
+			filepath.Ext(file.Name()) != storeSessionsExt {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(st.folder, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var session storedSession
+		if err := json.Unmarshal(data, &session); err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// loadAnnouncements reads the stored announcements.
+func (st *sessionStore) loadAnnouncements() ([]Announcement, error) {
+	var announcements []Announcement
+
+	data, err := ioutil.ReadFile(filepath.Join(st.folder, storeAnnouncementsFile))
+	if os.IsNotExist(err) {
+		return announcements, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &announcements)
+	return announcements, err
+}
+
+// saveAnnouncements stores the announcements, replacing the previous ones.
+func (st *sessionStore) saveAnnouncements(announcements []Announcement) error {
+	return st.write(storeAnnouncementsFile, announcements)
+}
+
+// remove deletes a stored session, which should already be marked as removed
+// so it isn't written again.
+func (st *sessionStore) remove(sid string) error {
+	st.lock.Lock()
+	delete(st.dirty, sid)
+	st.lock.Unlock()
+
+	st.writeLock.Lock()
+	defer st.writeLock.Unlock()
+
+	err := os.Remove(filepath.Join(st.folder, sid+storeSessionsExt))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// write atomically replaces a file with the JSON encoding of v, so a crash
+// never leaves it half written.
+func (st *sessionStore) write(name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return st.writeData(name, data)
+}
+
+// writeData atomically replaces a file with the specified contents.
+func (st *sessionStore) writeData(name string, data []byte) error {
+	if strings.ContainsAny(name, `/\`) {
+		return os.ErrInvalid
+	}
+
+	path := filepath.Join(st.folder, name)
+	if err := ioutil.WriteFile(path+storeTemporaryExt, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+storeTemporaryExt, path)
+}
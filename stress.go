@@ -0,0 +1,406 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultStressRuns = 100
+	maxStressRuns     = 1000
+
+	// stressRunsPerSize is how many seeds are tried with each size before
+	// moving to a larger one, so the first failing input found is as small
+	// as possible.
+	stressRunsPerSize = 10
+
+	stressTimeLimit        = 2 * time.Minute
+	stressProgramTimeLimit = 10 * time.Second
+	maxStressShown         = 1 << 16 // 64KB
+
+	// maxStressVerdicts is how many stress test verdicts each session keeps
+	maxStressVerdicts = 10
+)
+
+// Programs of a stress test
+const (
+	StressGenerator = "generator"
+	StressBrute     = "brute"
+	StressCandidate = "candidate"
+)
+
+// StressProgram is the code of one of the programs of a stress test.
+type StressProgram struct {
+	Code []byte
+	Lang Language
+}
+
+// StressTest stores information related to a stress test requested by the
+// user: the generator is called with a seed and a size as arguments, and its
+// output is given as input to both the brute force and the candidate
+// solutions. The output of the candidate is judged as that of a submission,
+// by the task checker or comparator, with the output of the brute force as
+// the expected one.
+type StressTest struct {
+	ID        uint32
+	SID       string
+	When      time.Time
+	TaskName  string
+	Generator StressProgram
+	Brute     StressProgram
+	Candidate StressProgram
+	Runs      int
+	DB        *Database
+	Key       []byte
+}
+
+// StressVerdict is used to indicate the verdict of a stress test. When a
+// program fails to compile or to run, Program tells which one it was, with
+// Compilation, Result and Extra describing the failure. A failure of the
+// candidate is a mismatch, in which case Input, Expected and Output hold the
+// failing input and the outputs of both solutions.
+type StressVerdict struct {
+	VerdictInfo
+	Compilation int
+	Program     string
+	Result      int
+	Runs        int
+	Seed        int
+	Size        int
+	Mismatch    bool
+	Input       string
+	Expected    string
+	Output      string
+	Error       bool
+	Extra       string
+}
+
+// SendStressTest is used to request that a judge instance runs a stress test.
+// It fails with ErrQueueFull if there are too many items waiting to be
+// judged.
+func (j *Judge) SendStressTest(t StressTest) (uint32, error) {
+	t.ID = atomic.AddUint32(&j.stressID, 1)
+	if err := j.queue.push(&queueItem{stress: &t, priority: priorityStressTest}); err != nil {
+		return 0, err
+	}
+	return t.ID, nil
+}
+
+func (w *judgeWorker) runStressTest(t StressTest) {
+	verdict := w.stress(t)
+
+	verdict.ID = t.ID
+	verdict.SID = t.SID
+	verdict.When = t.When
+	verdict.TaskName = t.TaskName
+	verdict.Code = string(t.Candidate.Code)
+	verdict.LangMime = t.Candidate.Lang.MimeType()
+	verdict.LangName = t.Candidate.Lang.Name()
+
+	if testingFlag {
+		fmt.Printf("%+v\n\n", verdict)
+	}
+	w.stressVerdictChannel <- verdict
+}
+
+// stressBox is a sandbox holding one of the compiled programs of a stress
+// test.
+type stressBox struct {
+	box        *Box
+	lang       Language
+	executable string
+}
+
+func (w *judgeWorker) stress(t StressTest) StressVerdict {
+	w.unload()
+
+	task, err := t.DB.Task(t.TaskName)
+	if err != nil {
+		return StressVerdict{Error: true, Extra: err.Error()}
+	}
+
+	switch task.Type {
+	case "", TaskTypeBatch:
+	default:
+		return StressVerdict{Error: true, Extra: "Stress tests are only available for batch tasks"}
+	}
+
+	compare, err := NewComparator(task.Comparator)
+	if err != nil {
+		return StressVerdict{Error: true, Extra: err.Error()}
+	}
+
+	graders, err := t.DB.Graders(t.TaskName, t.Key)
+	if err != nil {
+		return StressVerdict{Error: true, Extra: err.Error()}
+	}
+
+	programs := []struct {
+		name    string
+		program StressProgram
+	}{
+		{StressGenerator, t.Generator},
+		{StressBrute, t.Brute},
+		{StressCandidate, t.Candidate},
+	}
+
+	boxes := make([]stressBox, len(programs))
+	for i, p := range programs {
+		// the generator is a standalone program, while both solutions are
+		// compiled with the task graders
+		var files []FileData
+		var sources []string
+		var executable string
+		if p.name == StressGenerator {
+			source := StressGenerator + p.program.Lang.SourceExtension()
+			files = []FileData{{Name: source, Content: p.program.Code}}
+			sources, executable = []string{source}, StressGenerator
+		} else {
+			files, sources, executable = withGraders(t.TaskName, p.program.Lang, p.program.Code, graders)
+		}
+
+		box, err := w.prepare(i, p.program.Lang, files)
+		if err != nil {
+			return StressVerdict{Error: true, Extra: err.Error()}
+		}
+		defer box.Clear()
+
+		compilationCommand := p.program.Lang.CompilationCommand(sources, executable)
+
+		ok, compilationResult, compilationExtra := w.compile(box, compilationCommand, nil)
+		if !ok {
+			return StressVerdict{Error: true, Program: p.name, Extra: compilationExtra}
+		} else if compilationResult != ResultCompSuccess {
+			return StressVerdict{Compilation: compilationResult, Program: p.name, Extra: compilationExtra}
+		}
+
+		boxes[i] = stressBox{box, p.program.Lang, executable}
+	}
+
+	// tasks with a checker may have several correct outputs, so the one of
+	// the brute force is only given to the checker as the answer. It has its
+	// own sandbox, as its files must not be read by the other programs.
+	var checker *taskProgram
+	if len(task.Checker) > 0 {
+		files, err := t.DB.Checker(t.TaskName, t.Key)
+		if err != nil {
+			return StressVerdict{Error: true, Extra: err.Error()}
+		}
+
+		checker, err = w.prepareTaskProgram(len(programs), files, task.Checker)
+		if err != nil {
+			return StressVerdict{Error: true, Extra: "Checker: " + err.Error()}
+		}
+		defer checker.box.Clear()
+	}
+
+	runs := t.Runs
+	if runs <= 0 {
+		runs = defaultStressRuns
+	} else if runs > maxStressRuns {
+		runs = maxStressRuns
+	}
+
+	ret := StressVerdict{Compilation: ResultCompSuccess, Result: ResultCorrect}
+	start := time.Now()
+
+	for run := 0; run < runs && time.Since(start) < stressTimeLimit; run++ {
+		seed, size := run+1, 1+run/stressRunsPerSize
+		args := []string{strconv.Itoa(seed), strconv.Itoa(size)}
+
+		// the generator always writes to its standard output
+		input, result, err := w.runStressProgram(boxes[0], args, &TaskData{}, nil, nil)
+		if err != nil {
+			return StressVerdict{Error: true, Extra: err.Error()}
+		} else if result.Result != ResultCorrect {
+			return stressFailure(ret, StressGenerator, result, seed, size, nil)
+		}
+
+		expected, result, err := w.runStressProgram(boxes[1], nil, &task, input, nil)
+		if err != nil {
+			return StressVerdict{Error: true, Extra: err.Error()}
+		} else if result.Result != ResultCorrect {
+			return stressFailure(ret, StressBrute, result, seed, size, input)
+		}
+
+		config := submissionConfig(Submission{Task: &task, Lang: boxes[2].lang}, boxes[2].executable)
+		output, result, err := w.runStressProgram(boxes[2], nil, &task, input, config)
+		if err != nil {
+			return StressVerdict{Error: true, Extra: err.Error()}
+		}
+
+		if result.Result == ResultCorrect {
+			checked, err := checkOutput(output, TestData{N: run, Input: input, Output: expected}, compare, checker)
+			if err != nil {
+				return StressVerdict{Error: true, Extra: err.Error()}
+			}
+			result.Result, result.Extra = checked.Result, checked.Extra
+		}
+
+		ret.Runs++
+
+		if result.Result != ResultCorrect {
+			ret = stressFailure(ret, StressCandidate, result, seed, size, input)
+			ret.Mismatch = true
+			ret.Expected = stressShown(expected)
+			ret.Output = stressShown(output)
+			return ret
+		}
+	}
+
+	return ret
+}
+
+// runStressProgram runs one of the programs of a stress test over an input,
+// under the specified configuration or, if config is nil, under the default
+// limits for the generator and the brute force, which are usually slow.
+func (w *judgeWorker) runStressProgram(p stressBox, args []string, task *TaskData, input []byte, config *BoxConfig) ([]byte, TestVerdict, error) {
+	var ret TestVerdict
+
+	if config == nil {
+		command := p.lang.EvaluationCommand(p.executable, args, 25<<19) // 2.5GB
+
+		config = &BoxConfig{
+			Path:          command[0],
+			Args:          command,
+			Env:           env,
+			EnableCgroups: true,
+			CPUTimeLimit:  stressProgramTimeLimit,
+			WallTimeLimit: stressProgramTimeLimit,
+			OutputLimit:   defaultOutputLimit,
+		}
+
+		if p.lang.UseMemoryLimit() {
+			config.MemoryLimit = 25 << 19 // 2.5GB
+		}
+	}
+
+	outputFile, err := os.Create(filepath.Join(p.box.BoxPath, "box", ".output"))
+	if err != nil {
+		return nil, ret, err
+	}
+	config.Stdout = outputFile
+
+	err = setupFileIO(p.box, task, input, config)
+	if err != nil {
+		outputFile.Close()
+		return nil, ret, err
+	}
+
+	result := p.box.Run(config)
+
+	outputFile.Close()
+	output, missing, err := readOutput(p.box, task)
+	if err != nil {
+		return nil, ret, err
+	}
+
+	if result.Status == StatusError {
+		return nil, ret, errors.New(result.Error)
+	}
+
+	ret.setStatus(result)
+
+	if ret.Result == ResultCorrect && missing {
+		ret.Result = ResultWrong
+		ret.Extra = "Output file " + task.OutputFile + " was not created"
+	}
+
+	return output, ret, nil
+}
+
+// stressFailure returns the verdict of a stress test stopped because a
+// program failed with the specified seed and size.
+func stressFailure(v StressVerdict, program string, result TestVerdict, seed, size int, input []byte) StressVerdict {
+	v.Program = program
+	v.Result = result.Result
+	v.Extra = result.Extra
+	v.Seed = seed
+	v.Size = size
+	v.Input = stressShown(input)
+	return v
+}
+
+// stressShown truncates an input or output too large to be shown.
+func stressShown(data []byte) string {
+	if len(data) > maxStressShown {
+		return string(data[:maxStressShown]) + "\n\n(...)"
+	}
+	return string(data)
+}
+
+// ReadStressProgram reads the code of a program of a stress test from a file,
+// whose language is the one named langName or, if it's empty, the one guessed
+// from the file extension.
+func ReadStressProgram(path, langName string) (StressProgram, error) {
+	code, err := ioutil.ReadFile(path)
+	if err != nil {
+		return StressProgram{}, err
+	}
+
+	var lang Language
+	if len(langName) > 0 {
+		lang = LanguageByName(langName)
+		if lang == nil {
+			return StressProgram{}, errors.New("Language " + langName + " doesn't have a runner")
+		}
+	} else {
+		lang = LanguageByExtension(filepath.Ext(path))
+		if lang == nil {
+			return StressProgram{}, errors.New("Can't guess the language of " + path)
+		}
+	}
+
+	return StressProgram{code, lang}, nil
+}
+
+// RunStressTest sends a stress test to the judge and waits for its verdict,
+// for use without the web interface.
+func RunStressTest(judge *Judge, t StressTest) (StressVerdict, error) {
+	id, err := judge.SendStressTest(t)
+	if err != nil {
+		return StressVerdict{}, err
+	}
+
+	for v := range judge.StressVerdictChannel {
+		if v.ID == id {
+			return v, nil
+		}
+	}
+
+	return StressVerdict{}, errors.New("The judge was stopped")
+}
+
+// WriteStressReport prints the verdict of a stress test, with the failing
+// input and both outputs in case of a mismatch.
+func WriteStressReport(w io.Writer, v StressVerdict) {
+	result := "correct"
+	if key, ok := resultKeys[v.Result]; ok {
+		result = strings.TrimPrefix(key, "result_")
+	}
+
+	switch {
+	case v.Error:
+		fmt.Fprintf(w, "Error: %s\n", v.Extra)
+	case v.Compilation != ResultCompSuccess:
+		fmt.Fprintf(w, "The %s didn't compile:\n%s\n", v.Program, v.Extra)
+	case v.Mismatch:
+		fmt.Fprintf(w, "Mismatch after %d runs, with seed %d and size %d: %s %s\n", v.Runs, v.Seed, v.Size, result, v.Extra)
+		fmt.Fprintf(w, "\nInput:\n%s\nBrute force output:\n%s\nCandidate output:\n%s\n", v.Input, v.Expected, v.Output)
+	case len(v.Program) > 0:
+		fmt.Fprintf(w, "The %s failed with seed %d and size %d: %s %s\n", v.Program, v.Seed, v.Size, result, v.Extra)
+		if len(v.Input) > 0 {
+			fmt.Fprintf(w, "\nInput:\n%s\n", v.Input)
+		}
+	default:
+		fmt.Fprintf(w, "No differences found in %d runs\n", v.Runs)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	// ErrContestNotStarted is returned for submissions sent before the start
+	// of the contest.
+	ErrContestNotStarted = errors.New("The contest hasn't started yet")
+
+	// ErrContestEnded is returned for submissions sent after the end of the
+	// contest, when only custom tests are allowed.
+	ErrContestEnded = errors.New("The contest has ended, only custom tests are allowed")
+)
+
+// ContestTimer holds the moments in which the contest of a session starts,
+// ends and has its results frozen. End and Freeze are zero when the contest
+// has no duration or is never frozen, and a contest without an end is never
+// frozen.
+type ContestTimer struct {
+	Start  time.Time
+	End    time.Time
+	Freeze time.Time
+}
+
+// Timer returns the timer of the contest for a contestant who logged in for
+// the first time at firstLogin, which is when the countdown starts unless the
+// contest has a fixed StartTime.
+func (c ContestData) Timer(firstLogin time.Time) ContestTimer {
+	timer := ContestTimer{Start: c.StartTime}
+	if timer.Start.IsZero() {
+		timer.Start = firstLogin
+	}
+
+	if c.Duration > 0 {
+		timer.End = timer.Start.Add(time.Duration(c.Duration) * time.Minute)
+	}
+
+	if c.FreezeAfter > 0 && !timer.End.IsZero() {
+		timer.Freeze = timer.Start.Add(time.Duration(c.FreezeAfter) * time.Minute)
+	}
+
+	return timer
+}
+
+// Check returns an error if submissions can't be sent at the specified
+// moment.
+func (t ContestTimer) Check(now time.Time) error {
+	if now.Before(t.Start) {
+		return ErrContestNotStarted
+	} else if t.Ended(now) {
+		return ErrContestEnded
+	}
+	return nil
+}
+
+// Ended reports whether the contest is over at the specified moment.
+func (t ContestTimer) Ended(now time.Time) bool {
+	return !t.End.IsZero() && !now.Before(t.End)
+}
+
+// Frozen reports whether the results of a submission sent at when are still
+// hidden at the moment now, which is the case until the end of the contest
+// for submissions sent after the freeze.
+func (t ContestTimer) Frozen(when, now time.Time) bool {
+	return !t.Freeze.IsZero() && !when.Before(t.Freeze) && !t.Ended(now)
+}
+
+// Elapsed returns the time since the start of the contest.
+func (t ContestTimer) Elapsed(now time.Time) time.Duration {
+	if now.Before(t.Start) {
+		return 0
+	}
+	return now.Sub(t.Start)
+}
+
+// Remaining returns the time left until the end of the contest, or until its
+// start if it hasn't started yet. It's zero for contests without a duration.
+func (t ContestTimer) Remaining(now time.Time) time.Duration {
+	if now.Before(t.Start) {
+		return t.Start.Sub(now)
+	} else if t.End.IsZero() || t.Ended(now) {
+		return 0
+	}
+	return t.End.Sub(now)
+}
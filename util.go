@@ -9,8 +9,6 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
-	"strings"
-	"unicode"
 )
 
 // Compress a []byte with gzip
@@ -101,24 +99,3 @@ func decrypt(ciphertext []byte, key []byte) (plaintext []byte, err error) {
 		nil,
 	)
 }
-
-// Strips all repeated space characters and newlines from a string
-func strip(s string) string {
-	out := strings.Builder{}
-	out.Grow(len(s))
-
-	white := false
-	for _, c := range s {
-		if unicode.IsSpace(c) {
-			if !white {
-				out.WriteString(" ")
-			}
-			white = true
-		} else {
-			out.WriteRune(c)
-			white = false
-		}
-	}
-
-	return out.String()
-}